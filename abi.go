@@ -0,0 +1,246 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var errGoType = errors.New("wasm: value type has no Go binding")
+
+// ABIFingerprint returns a hash of the imports and exports of the module,
+// with their signatures. Modules with the same ABI share a fingerprint
+// whatever their code, the order of imports and exports is ignored.
+func (m Module) ABIFingerprint() string {
+	mc := m.context()
+	var lines []string
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			lines = append(lines, fmt.Sprintf("import %q %q %s",
+				imp.Module, imp.Field, importType(&mc, &imp)))
+		}
+	}
+	if s, ok := m.section(ExportID).(ExportSection); ok {
+		for _, exp := range s.Exports {
+			lines = append(lines, fmt.Sprintf("export %q %s %s",
+				exp.Field, exp.Kind, m.externType(&mc, exp.Kind, exp.Index)))
+		}
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// externType describes the type of the entity of the given kind at idx,
+// imported or defined.
+func (m Module) externType(mc *moduleContext, kind ExternalKind, idx uint32) string {
+	switch kind {
+	case FunctionKind:
+		return typeString(mc.funcType(idx))
+	case GlobalKind:
+		if int64(idx) < int64(len(mc.globals)) {
+			return mc.globals[idx].String()
+		}
+	case MemoryKind:
+		if limits, ok := m.memoryLimits(idx); ok {
+			return limits.String()
+		}
+	case TableKind:
+		var tables []TableType
+		if s, ok := m.section(ImportID).(ImportSection); ok {
+			for _, imp := range s.Imports {
+				if tt, ok := imp.Typ.(TableType); ok {
+					tables = append(tables, tt)
+				}
+			}
+		}
+		if s, ok := m.section(TableID).(TableSection); ok {
+			tables = append(tables, s.tables...)
+		}
+		if int64(idx) < int64(len(tables)) {
+			return tables[idx].String()
+		}
+	}
+	return "unknown"
+}
+
+// importType describes the kind and type of an import.
+func importType(mc *moduleContext, imp *ImportEntry) string {
+	switch typ := imp.Typ.(type) {
+	case uint32:
+		return imp.Kind.String() + " " + typeString(mc.typeAt(typ))
+	case fmt.Stringer:
+		return imp.Kind.String() + " " + typ.String()
+	}
+	return imp.Kind.String()
+}
+
+func typeString(ft *FuncType) string {
+	if ft == nil {
+		return "unknown"
+	}
+	return ft.String()
+}
+
+// Symbol is an import or an export of a module.
+type Symbol struct {
+	Module string // module imported from, empty for exports
+	Name   string
+	Kind   ExternalKind
+	Type   *FuncType // signature of a function, nil for the other kinds
+}
+
+// Symbols lists the imports and exports of a module.
+type Symbols struct {
+	Imports []Symbol
+	Exports []Symbol
+}
+
+// Symbols returns the imports and exports of the module in module order,
+// with the signatures of the functions.
+func (m Module) Symbols() Symbols {
+	mc := m.context()
+	var syms Symbols
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			sym := Symbol{Module: imp.Module, Name: imp.Field, Kind: imp.Kind}
+			if idx, ok := imp.Typ.(uint32); ok {
+				sym.Type = mc.typeAt(idx)
+			}
+			syms.Imports = append(syms.Imports, sym)
+		}
+	}
+	if s, ok := m.section(ExportID).(ExportSection); ok {
+		for _, exp := range s.Exports {
+			sym := Symbol{Name: exp.Field, Kind: exp.Kind}
+			if exp.Kind == FunctionKind {
+				sym.Type = mc.funcType(exp.Index)
+			}
+			syms.Exports = append(syms.Exports, sym)
+		}
+	}
+	return syms
+}
+
+// goTypes maps the value types with a Go binding to their Go type.
+var goTypes = map[ValueType]string{
+	ValueI32: "int32",
+	ValueI64: "int64",
+	ValueF32: "float32",
+	ValueF64: "float64",
+}
+
+// GoBindings writes to w a Go source file of package pkg declaring the
+// struct Module, with a field for each imported function to be provided by
+// the host and a stub method for each exported function. The names are
+// turned into exported Go identifiers, imports are prefixed by their module
+// name. Only i32, i64, f32 and f64 values are supported.
+func (m Module) GoBindings(pkg string, w io.Writer) error {
+	syms := m.Symbols()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by wasm GoBindings. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	buf.WriteString("// Module holds the imports and exports of the WebAssembly module.\n")
+	buf.WriteString("type Module struct {\n")
+	used := map[string]bool{}
+	for _, sym := range syms.Imports {
+		if sym.Kind != FunctionKind {
+			continue
+		}
+		sig, err := goSignature(sym.Type)
+		if err != nil {
+			return fmt.Errorf("%w: import %s.%s", err, sym.Module, sym.Name)
+		}
+		name := goIdent(sym.Module+"_"+sym.Name, used)
+		fmt.Fprintf(&buf, "\t// %s is the import %q %q.\n\t%s func%s\n", name, sym.Module, sym.Name, name, sig)
+	}
+	buf.WriteString("}\n")
+	for _, sym := range syms.Exports {
+		if sym.Kind != FunctionKind {
+			continue
+		}
+		sig, err := goSignature(sym.Type)
+		if err != nil {
+			return fmt.Errorf("%w: export %s", err, sym.Name)
+		}
+		name := goIdent(sym.Name, used)
+		fmt.Fprintf(&buf, "\n// %s is a stub of the export %q.\nfunc (m *Module) %s%s {\n\tpanic(\"not implemented\")\n}\n", name, sym.Name, name, sig)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// goSignature returns the Go parameters and results of ft.
+func goSignature(ft *FuncType) (string, error) {
+	if ft == nil {
+		return "", errBadIndex
+	}
+	var params, results []string
+	for i, vt := range ft.Params() {
+		t, ok := goTypes[vt]
+		if !ok {
+			return "", errGoType
+		}
+		params = append(params, fmt.Sprintf("p%d %s", i, t))
+	}
+	for _, vt := range ft.Results() {
+		t, ok := goTypes[vt]
+		if !ok {
+			return "", errGoType
+		}
+		results = append(results, t)
+	}
+	sig := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+	case 1:
+		sig += " " + results[0]
+	default:
+		sig += " (" + strings.Join(results, ", ") + ")"
+	}
+	return sig, nil
+}
+
+// goIdent turns name into an exported Go identifier not in used, which it is
+// added to.
+func goIdent(name string, used map[string]bool) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if sb.Len() == 0 && unicode.IsDigit(r) {
+			sb.WriteByte('X')
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	if sb.Len() == 0 {
+		sb.WriteByte('X')
+	}
+	id := sb.String()
+	for i := 1; used[id]; i++ {
+		id = fmt.Sprintf("%s%d", sb.String(), i)
+	}
+	used[id] = true
+	return id
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestABIFingerprint(t *testing.T) {
+	a, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same ABI, a different body and export order
+	code := b.section(CodeID).(CodeSection)
+	body := code.Bodies[0].Code
+	i := bytes.Index(body, []byte{byte(Op_i32_const), 0x08})
+	if i < 0 {
+		t.Fatalf("i32.const 8 not found in %x", body)
+	}
+	body = append([]byte{}, body...)
+	body[i+1] = 0x09
+	code.Bodies[0].Code = body
+	es := b.section(ExportID).(ExportSection)
+	es.Exports[0], es.Exports[1] = es.Exports[1], es.Exports[0]
+
+	fa, fb := a.ABIFingerprint(), b.ABIFingerprint()
+	if fa != fb {
+		t.Errorf("same ABI: fingerprints %s and %s differ", fa, fb)
+	}
+	if len(fa) != 64 {
+		t.Errorf("fingerprint %q, want 64 hex digits", fa)
+	}
+
+	if err := b.AddExport("run", FunctionKind, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fb = b.ABIFingerprint(); fa == fb {
+		t.Errorf("added export: fingerprint unchanged %s", fa)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	buf, err := BuildEwasmModule([]ImportEntry{
+		{Module: "ethereum", Field: "getCallDataSize", Kind: FunctionKind},
+		{Module: "ethereum", Field: "finish", Kind: FunctionKind},
+	}, FunctionBody{Code: []byte{0x0b}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms := mod.Symbols()
+
+	imports := []struct {
+		name string
+		typ  string
+	}{
+		{"getCallDataSize", "(func (result i32))"},
+		{"finish", "(func (param i32 i32))"},
+	}
+	if len(syms.Imports) != len(imports) {
+		t.Fatalf("got imports %+v, want %d", syms.Imports, len(imports))
+	}
+	for i, sym := range syms.Imports {
+		if sym.Module != "ethereum" || sym.Name != imports[i].name || sym.Kind != FunctionKind ||
+			sym.Type == nil || sym.Type.String() != imports[i].typ {
+			t.Errorf("import %d: got %+v, want ethereum.%s %s", i, sym, imports[i].name, imports[i].typ)
+		}
+	}
+
+	if len(syms.Exports) != 2 {
+		t.Fatalf("got exports %+v, want main and memory", syms.Exports)
+	}
+	if sym := syms.Exports[0]; sym.Name != "main" || sym.Kind != FunctionKind ||
+		sym.Type == nil || sym.Type.String() != "(func)" {
+		t.Errorf("got export %+v, want func main", sym)
+	}
+	if sym := syms.Exports[1]; sym.Name != "memory" || sym.Kind != MemoryKind || sym.Type != nil {
+		t.Errorf("got export %+v, want memory", sym)
+	}
+}
+
+func TestGoBindings(t *testing.T) {
+	var b ModuleBuilder
+	b.AddImportFunc("ethereum", "finish", NewFuncType([]ValueType{ValueI32, ValueI32}, nil))
+	b.AddImportFunc("debug", "print64", NewFuncType([]ValueType{ValueI64}, nil))
+	main := b.AddFunc(NewFuncType(nil, nil), FunctionBody{Code: []byte{Op_end}})
+	add := b.AddFunc(NewFuncType([]ValueType{ValueF64, ValueF64}, []ValueType{ValueF64}),
+		FunctionBody{Code: []byte{0x20, 0x00, 0x20, 0x01, 0xa0, Op_end}})
+	b.AddMemory(ResizableLimits{Initial: 1})
+	b.AddExport("main", FunctionKind, main)
+	b.AddExport("add_f64", FunctionKind, add)
+	b.AddExport("memory", MemoryKind, 0)
+	mod, err := b.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := mod.GoBindings("contract", &buf); err != nil {
+		t.Fatal(err)
+	}
+	const golden = "testdata/bindings.golden"
+	if *update {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("GoBindings:\n%s\nwant:\n%s", buf.Bytes(), want)
+	}
+
+	b.AddExport("v128", FunctionKind, b.AddFunc(NewFuncType(nil, []ValueType{ValueV128}),
+		FunctionBody{Code: []byte{byte(Op_unreachable), Op_end}}))
+	if mod, err = b.Module(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.GoBindings("contract", &buf); !errors.Is(err, errGoType) {
+		t.Errorf("v128 result: got %v, want %v", err, errGoType)
+	}
+}
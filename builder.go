@@ -0,0 +1,138 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import "errors"
+
+var errImportOrder = errors.New("wasm: function imported after a function was defined")
+
+// ModuleBuilder builds a module incrementally, the zero value is an empty
+// module. The Add methods return the index of the entity they add, errors
+// are reported by Build.
+//
+// Functions must be imported before any function is defined, since imported
+// functions come first in the function index space.
+type ModuleBuilder struct {
+	types   []FuncType
+	imports []ImportEntry
+	funcs   []uint32
+	bodies  []FunctionBody
+	mems    []MemoryType
+	globals []GlobalVariable
+	exports []ExportEntry
+	data    []DataSegment
+	nImport uint32 // imported functions
+	err     error
+}
+
+// AddType returns the index of the function type sig, reusing an equal type.
+func (b *ModuleBuilder) AddType(sig FuncType) uint32 {
+	if sig.form == 0 {
+		sig.form = ValueFunc
+	}
+	for i := range b.types {
+		if b.types[i].equal(&sig) {
+			return uint32(i)
+		}
+	}
+	b.types = append(b.types, sig)
+	return uint32(len(b.types) - 1)
+}
+
+// AddImportFunc imports the host function module.field of signature sig.
+func (b *ModuleBuilder) AddImportFunc(module, field string, sig FuncType) uint32 {
+	if len(b.funcs) > 0 && b.err == nil {
+		b.err = errImportOrder
+	}
+	b.imports = append(b.imports, ImportEntry{
+		Module: module,
+		Field:  field,
+		Kind:   FunctionKind,
+		Typ:    b.AddType(sig),
+	})
+	b.nImport++
+	return b.nImport - 1
+}
+
+// AddFunc defines a function of signature sig, the code of body must end
+// with an end instruction.
+func (b *ModuleBuilder) AddFunc(sig FuncType, body FunctionBody) uint32 {
+	b.funcs = append(b.funcs, b.AddType(sig))
+	b.bodies = append(b.bodies, body)
+	return b.nImport + uint32(len(b.funcs)-1)
+}
+
+// AddMemory defines a memory of the given limits.
+func (b *ModuleBuilder) AddMemory(limits ResizableLimits) uint32 {
+	b.mems = append(b.mems, MemoryType{Limits: limits})
+	return uint32(len(b.mems) - 1)
+}
+
+// AddGlobal defines a global of type typ initialized by init.
+func (b *ModuleBuilder) AddGlobal(typ GlobalType, init InitExpr) uint32 {
+	b.globals = append(b.globals, GlobalVariable{Type: typ, Init: init})
+	return uint32(len(b.globals) - 1)
+}
+
+// AddExport exports the entity index of kind as name.
+func (b *ModuleBuilder) AddExport(name string, kind ExternalKind, index uint32) {
+	for _, ee := range b.exports {
+		if ee.Field == name && b.err == nil {
+			b.err = errDupExport
+		}
+	}
+	b.exports = append(b.exports, ExportEntry{Field: name, Kind: kind, Index: index})
+}
+
+// AddData places data at offset in the memory 0.
+func (b *ModuleBuilder) AddData(offset uint32, data []byte) {
+	b.data = append(b.data, DataSegment{
+		Offset: InitExpr{Op: Op_i32_const, Value: int64(int32(offset))},
+		Data:   data,
+	})
+}
+
+// Module returns the module built, its sections in canonical order and
+// the empty ones omitted.
+func (b *ModuleBuilder) Module() (Module, error) {
+	m := Module{Header: ModuleHeader{Magic: magicWASM, Version: 1}}
+	if b.err != nil {
+		return m, b.err
+	}
+	if len(b.types) > 0 {
+		m.Sections = append(m.Sections, TypeSection{Types: b.types})
+	}
+	if len(b.imports) > 0 {
+		m.Sections = append(m.Sections, ImportSection{Imports: b.imports})
+	}
+	if len(b.funcs) > 0 {
+		m.Sections = append(m.Sections, FunctionSection{Types: b.funcs})
+	}
+	if len(b.mems) > 0 {
+		m.Sections = append(m.Sections, MemorySection{memories: b.mems})
+	}
+	if len(b.globals) > 0 {
+		m.Sections = append(m.Sections, GlobalSection{globals: b.globals})
+	}
+	if len(b.exports) > 0 {
+		m.Sections = append(m.Sections, ExportSection{Exports: b.exports})
+	}
+	if len(b.bodies) > 0 {
+		m.Sections = append(m.Sections, CodeSection{Bodies: b.bodies})
+	}
+	if len(b.data) > 0 {
+		m.Sections = append(m.Sections, DataSection{segments: b.data})
+	}
+	return m, nil
+}
+
+// Build returns the encoded module.
+func (b *ModuleBuilder) Build() ([]byte, error) {
+	m, err := b.Module()
+	if err != nil {
+		return nil, err
+	}
+	return m.Bytes()
+}
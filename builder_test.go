@@ -0,0 +1,56 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import "testing"
+
+func TestModuleBuilder(t *testing.T) {
+	var b ModuleBuilder
+	i32 := []ValueType{ValueI32}
+	log := b.AddImportFunc("env", "log", NewFuncType(i32, nil))
+	add := b.AddFunc(NewFuncType([]ValueType{ValueI32, ValueI32}, i32), FunctionBody{Code: []byte{
+		0x20, 0x00, // local.get 0
+		0x20, 0x01, // local.get 1
+		0x6a, // i32.add
+		0x0b,
+	}})
+	run := b.AddFunc(NewFuncType(nil, nil), FunctionBody{Code: []byte{
+		0x41, 0x01, // i32.const 1
+		0x41, 0x02, // i32.const 2
+		0x10, byte(add), // call add
+		0x10, byte(log), // call log
+		0x0b,
+	}})
+	b.AddExport("add", FunctionKind, add)
+	b.AddExport("run", FunctionKind, run)
+	b.AddExport("memory", MemoryKind, b.AddMemory(ResizableLimits{Initial: 1}))
+	b.AddData(16, []byte("hello"))
+
+	if log != 0 || add != 1 || run != 2 {
+		t.Errorf("got function indices %d, %d, %d, want 0, 1, 2", log, add, run)
+	}
+	buf, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	if err := mod.TypeCheck(); err != nil {
+		t.Errorf("TypeCheck: %v", err)
+	}
+	if ts := mod.section(TypeID).(TypeSection); len(ts.Types) != 3 {
+		t.Errorf("got %d types, want 3", len(ts.Types))
+	}
+
+	b.AddImportFunc("env", "late", NewFuncType(nil, nil))
+	if _, err := b.Build(); err != errImportOrder {
+		t.Errorf("import after a function: got %v, want %v", err, errImportOrder)
+	}
+}
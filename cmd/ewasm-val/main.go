@@ -33,14 +33,11 @@ func main() {
 		}
 		f.Close()
 	}
-	var mod wasm.ValModule
-	if err := mod.ReadValModule(inBuff); err != nil {
-		log.Fatal("Read and Validate Module", err)
+	out, err := wasm.ValidateAndStrip(inBuff)
+	if err != nil {
+		log.Fatal("Validate Module ", err)
 	}
-	if err := mod.Validate(); err != nil {
-		log.Fatal("Module Validate()", err)
-	}
-	if err := ioutil.WriteFile(oname, mod.Bytes(), 0666); err != nil {
+	if err := ioutil.WriteFile(oname, out, 0666); err != nil {
 		log.Fatal("WriteFile", err)
 	}
 }
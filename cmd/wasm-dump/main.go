@@ -40,7 +40,7 @@ func main() {
 			}
 		} else if section.ID() == wasm.UnknownID {
 			sec := section.(wasm.NameSection)
-			fmt.Printf("Custom Section (%s), size: %d\n", sec.Name, sec.Size)
+			fmt.Printf("Custom Section (%s), size: %d\n", sec.Name, sec.Size())
 			if len(sec.ModName) > 0 {
 				fmt.Printf("Module Name: %s\n", sec.ModName)
 			}
@@ -51,8 +51,12 @@ func main() {
 			s := section.(wasm.ImportSection)
 			fmt.Printf("Imports: %d\n", len(s.Imports))
 			for ii, imp := range s.Imports {
-				fmt.Printf("    entry[%d]: %q|%q|%s %v\n", ii, imp.Module,
-					imp.Field, imp.Kind, imp.Typ)
+				typ := fmt.Sprint(imp.Typ)
+				if idx, ok := imp.Typ.(uint32); ok {
+					typ = fmt.Sprintf("(type $%d)", idx)
+				}
+				fmt.Printf("    entry[%d]: %q|%q|%s %s\n", ii, imp.Module,
+					imp.Field, imp.Kind, typ)
 			}
 		}
 	}
@@ -0,0 +1,42 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/shbta/go-wasm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("wasm>> ")
+
+	flag.Parse()
+
+	os.Exit(validate(flag.Args()))
+}
+
+// validate validates the named WASM module files, it logs the first error
+// of each invalid module and returns the process exit code.
+func validate(names []string) int {
+	code := 0
+	for _, fname := range names {
+		mod, err := wasm.Open(fname)
+		if err == nil {
+			err = mod.ValidateMVP()
+		}
+		if err == nil {
+			err = mod.TypeCheck()
+		}
+		if err != nil {
+			log.Printf("%s: %v", fname, err)
+			code = 1
+		}
+	}
+	return code
+}
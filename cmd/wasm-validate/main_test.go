@@ -0,0 +1,58 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	hello := "../../testdata/hello.wasm"
+	if code := validate([]string{hello}); code != 0 {
+		t.Fatalf("validate(hello.wasm) = %d, want 0: %s", code, out.String())
+	}
+
+	buf, err := ioutil.ReadFile(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the function section of hello.wasm refers to type 1, point it at
+	// the missing type 5.
+	i := bytes.Index(buf, []byte{0x03, 0x02, 0x01, 0x01})
+	if i < 0 {
+		t.Fatal("function section not found")
+	}
+	buf[i+3] = 5
+	invalid := filepath.Join(dir, "invalid.wasm")
+	if err := ioutil.WriteFile(invalid, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if code := validate([]string{hello, invalid}); code != 1 {
+		t.Errorf("validate(invalid.wasm) = %d, want 1", code)
+	}
+	want := fmt.Sprintf("function section entry 0 at offset %d", i+3)
+	if got := out.String(); !strings.Contains(got, want) {
+		t.Errorf("validate(invalid.wasm) logged %q, want %q", got, want)
+	}
+}
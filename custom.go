@@ -0,0 +1,189 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var errCustom = errors.New("wasm: malformed custom section")
+
+// customDecoder returns a decoder reading a custom section payload.
+func customDecoder(payload []byte) (*decoder, *bytes.Reader) {
+	r := bytes.NewReader(payload)
+	return &decoder{r: r}, r
+}
+
+// readCount reads a vector length, rejecting lengths larger than the bytes
+// left in r.
+func (d *decoder) readCount(r *bytes.Reader, n *uint32) {
+	d.readVarU32(r, n)
+	if d.err == nil && int64(*n) > int64(r.Len()) {
+		d.err = errCustom
+	}
+}
+
+// customErr returns the error of a custom section decoder, trailing bytes
+// and truncated payloads are reported as errCustom.
+func customErr(d *decoder, r *bytes.Reader) error {
+	if d.err == io.EOF || d.err == io.ErrUnexpectedEOF || d.err == errLength ||
+		d.err == nil && r.Len() != 0 {
+		return errCustom
+	}
+	return d.err
+}
+
+// customPayload returns the payload of the first custom section called name.
+func (m Module) customPayload(name string) ([]byte, bool) {
+	if ps := m.CustomSections(name); len(ps) > 0 {
+		return ps[0], true
+	}
+	return nil, false
+}
+
+// TargetFeature is an entry of the "target_features" custom section.
+type TargetFeature struct {
+	Prefix byte // '+': used, '-': not used, '=': required
+	Name   string
+}
+
+func (tf TargetFeature) String() string {
+	return string(tf.Prefix) + tf.Name
+}
+
+// ParseTargetFeatures decodes the payload of a "target_features" section.
+func ParseTargetFeatures(payload []byte) ([]TargetFeature, error) {
+	d, r := customDecoder(payload)
+	var n uint32
+	d.readCount(r, &n)
+	if d.err != nil {
+		return nil, customErr(d, r)
+	}
+	features := make([]TargetFeature, int(n))
+	for i := range features {
+		var prefix [1]byte
+		d.read(r, prefix[:])
+		d.readString(r, &features[i].Name)
+		switch features[i].Prefix = prefix[0]; prefix[0] {
+		case '+', '-', '=':
+		default:
+			if d.err == nil {
+				d.err = errCustom
+			}
+		}
+	}
+	if err := customErr(d, r); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// TargetFeatures returns the features of the "target_features" section, or
+// nil if the module has none.
+func (m Module) TargetFeatures() ([]TargetFeature, error) {
+	payload, ok := m.customPayload("target_features")
+	if !ok {
+		return nil, nil
+	}
+	return ParseTargetFeatures(payload)
+}
+
+// DylinkInfo is the content of the "dylink.0" section of a side module.
+type DylinkInfo struct {
+	MemorySize  uint32   // size of the module static data
+	MemoryAlign uint32   // alignment of the static data, as a power of two
+	TableSize   uint32   // number of table entries needed
+	TableAlign  uint32   // alignment of the table entries, as a power of two
+	Needed      []string // dynamic libraries the module depends on
+}
+
+// dylink.0 subsection types
+const (
+	dylinkMemInfo = 1
+	dylinkNeeded  = 2
+)
+
+// subsection reads the type and payload of the next custom subsection.
+func (d *decoder) subsection(r *bytes.Reader, payload []byte) (byte, []byte) {
+	var typ [1]byte
+	var sz uint32
+	d.read(r, typ[:])
+	d.readCount(r, &sz)
+	if d.err != nil {
+		return 0, nil
+	}
+	pos := len(payload) - r.Len()
+	r.Seek(int64(sz), io.SeekCurrent)
+	return typ[0], payload[pos : pos+int(sz)]
+}
+
+// ParseDylink decodes the payload of a "dylink.0" section, unknown
+// subsections are skipped.
+func ParseDylink(payload []byte) (DylinkInfo, error) {
+	var info DylinkInfo
+	d, r := customDecoder(payload)
+	for d.err == nil && r.Len() > 0 {
+		typ, sub := d.subsection(r, payload)
+		sd, sr := customDecoder(sub)
+		switch typ {
+		case dylinkMemInfo:
+			sd.readVarU32(sr, &info.MemorySize)
+			sd.readVarU32(sr, &info.MemoryAlign)
+			sd.readVarU32(sr, &info.TableSize)
+			sd.readVarU32(sr, &info.TableAlign)
+		case dylinkNeeded:
+			var n uint32
+			sd.readCount(sr, &n)
+			if sd.err != nil {
+				break
+			}
+			info.Needed = make([]string, int(n))
+			for i := range info.Needed {
+				sd.readString(sr, &info.Needed[i])
+			}
+		default:
+			continue
+		}
+		if err := customErr(sd, sr); err != nil {
+			return info, err
+		}
+	}
+	return info, customErr(d, r)
+}
+
+// Dylink returns the decoded "dylink.0" section, it reports false if the
+// module has none or it is malformed.
+func (m Module) Dylink() (DylinkInfo, bool) {
+	payload, ok := m.customPayload("dylink.0")
+	if !ok {
+		return DylinkInfo{}, false
+	}
+	info, err := ParseDylink(payload)
+	return info, err == nil
+}
+
+// SourceMappingURL returns the URL of the source map held by the
+// "sourceMappingURL" section, it reports false if the module has none or
+// it is malformed.
+func (m Module) SourceMappingURL() (string, bool) {
+	payload, ok := m.customPayload("sourceMappingURL")
+	if !ok {
+		return "", false
+	}
+	d, r := customDecoder(payload)
+	var n uint32
+	d.readCount(r, &n)
+	if d.err != nil {
+		return "", false
+	}
+	url := make([]byte, int(n))
+	d.read(r, url)
+	if customErr(d, r) != nil {
+		return "", false
+	}
+	return string(url), true
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"testing"
+)
+
+// withCustom returns hello.wasm with a custom section appended, re-decoded.
+func withCustom(t *testing.T, name string, payload []byte) Module {
+	t.Helper()
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.Sections = append(mod.Sections, NameSection{Name: name, Payload: payload})
+	b, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	return mod
+}
+
+func TestTargetFeatures(t *testing.T) {
+	mod, err := Open("testdata/target_features.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, err := mod.TargetFeatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TargetFeature{{'+', "simd128"}, {'+', "bulk-memory"}}
+	if len(features) != len(want) {
+		t.Fatalf("got %d features, want %d", len(features), len(want))
+	}
+	for i := range want {
+		if features[i] != want[i] {
+			t.Errorf("feature[%d] = %s, want %s", i, features[i], want[i])
+		}
+	}
+
+	payload, _ := mod.customPayload("target_features")
+	if _, err := ParseTargetFeatures(payload[:len(payload)-1]); err != errCustom {
+		t.Errorf("truncated payload: got %v, want %v", err, errCustom)
+	}
+	payload = append([]byte{}, payload...)
+	payload[0] = 5
+	if _, err := ParseTargetFeatures(payload); err != errCustom {
+		t.Errorf("missing features: got %v, want %v", err, errCustom)
+	}
+	payload[0], payload[1] = 2, '*'
+	if _, err := ParseTargetFeatures(payload); err != errCustom {
+		t.Errorf("invalid prefix: got %v, want %v", err, errCustom)
+	}
+
+	hello, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features, err := hello.TargetFeatures(); features != nil || err != nil {
+		t.Errorf("TargetFeatures() = %v, %v, want nil", features, err)
+	}
+}
+
+func TestDylink(t *testing.T) {
+	// memory info, an unknown subsection and the needed libraries
+	mod, err := Open("testdata/dylink.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := mod.Dylink()
+	if !ok {
+		t.Fatal("dylink.0 section missing")
+	}
+	if info.MemorySize != 400 || info.MemoryAlign != 2 || info.TableSize != 1 || info.TableAlign != 0 {
+		t.Errorf("got %+v, want memory 400/2, table 1/0", info)
+	}
+	if len(info.Needed) != 2 || info.Needed[0] != "liba" || info.Needed[1] != "libbc" {
+		t.Errorf("Needed = %q, want [liba libbc]", info.Needed)
+	}
+
+	payload, _ := mod.customPayload("dylink.0")
+	if _, err := ParseDylink(payload[:len(payload)-1]); err != errCustom {
+		t.Errorf("truncated payload: got %v, want %v", err, errCustom)
+	}
+	if _, ok := withCustom(t, "other", payload).Dylink(); ok {
+		t.Errorf("Dylink() reports a section for a module without dylink.0")
+	}
+}
+
+func TestSourceMappingURL(t *testing.T) {
+	url := "http://example.com/hello.wasm.map"
+	payload := append([]byte{byte(len(url))}, url...)
+	got, ok := withCustom(t, "sourceMappingURL", payload).SourceMappingURL()
+	if !ok || got != url {
+		t.Errorf("SourceMappingURL() = %q, %v, want %q", got, ok, url)
+	}
+
+	if _, ok := withCustom(t, "sourceMappingURL", payload[:10]).SourceMappingURL(); ok {
+		t.Errorf("truncated URL reported")
+	}
+	if _, ok := withCustom(t, "other", payload).SourceMappingURL(); ok {
+		t.Errorf("SourceMappingURL() reports a section for a module without one")
+	}
+}
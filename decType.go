@@ -5,16 +5,54 @@
 package wasm
 
 import (
-	//"bytes"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 )
 
 type decoder struct {
-	r   io.Reader
-	err error
+	r    io.Reader
+	err  error
+	opts Options
+	data uint64 // bytes of data segments read so far
+	buf  []byte // scratch buffer reused by readString
+	off  int64  // offset in the module of the next section
+
+	// overlong records an over-long LEB128 read by a non-strict decoder,
+	// it is cleared at the start of every section.
+	overlong bool
+
+	start   int64   // offset in the module of the section being read
+	size    int     // size of its body
+	entries []int64 // offsets in the module of its entries read so far
+}
+
+// mark records the offset of the entry about to be read from the section
+// reader r.
+func (d *decoder) mark(r io.Reader) {
+	d.entries = append(d.entries, d.off-bytesLeft(r))
+}
+
+// checkLEB128 handles a LEB128 read on n bytes whose minimal encoding takes
+// min bytes.
+func (d *decoder) checkLEB128(n, min int) {
+	if d.err != nil || n == min {
+		return
+	}
+	if d.opts.Strict {
+		d.err = errNonCanonical
+		return
+	}
+	d.overlong = true
+}
+
+// checkLimit sets a LimitError if n exceeds max, a zero max is no limit.
+func (d *decoder) checkLimit(limit string, n uint64, max int) {
+	if d.err == nil && max > 0 && n > uint64(max) {
+		d.err = &LimitError{Limit: limit, Max: max}
+	}
 }
 
 func (d *decoder) readVarI7(r io.Reader, v *int32) {
@@ -34,8 +72,10 @@ func (d *decoder) readVarI32(r io.Reader, v *int32) {
 	if d.err != nil {
 		return
 	}
+	var n int
 	var vv int64
-	vv, _, d.err = varint(r)
+	vv, n, d.err = varint(r)
+	d.checkLEB128(n, varintLen(vv))
 	*v = int32(vv)
 }
 
@@ -43,12 +83,25 @@ func (d *decoder) readVarI64(r io.Reader, v *int64) {
 	if d.err != nil {
 		return
 	}
-	*v, _, d.err = varint(r)
+	var n int
+	*v, n, d.err = varint(r)
+	d.checkLEB128(n, varintLen(*v))
+}
+
+func (d *decoder) readVarU64(r io.Reader, v *uint64) {
+	if d.err != nil {
+		return
+	}
+	var n int
+	*v, n, d.err = uvarint64(r)
+	d.checkLEB128(n, uvarintLen(*v))
 }
 
 func (d *decoder) readVarU1(r io.Reader, v *uint32) {
-	// FIXME ?
 	d.readVarU7(r, v)
+	if d.err == nil && *v > 1 {
+		d.err = errMalform
+	}
 }
 
 func (d *decoder) readVarU7(r io.Reader, v *uint32) {
@@ -62,11 +115,38 @@ func (d *decoder) readVarU7(r io.Reader, v *uint32) {
 	}
 }
 
-func (d *decoder) readVarU32(r io.Reader, v *uint32) {
+// readVarU32 reads a varuint32 into v and returns the number of bytes read.
+func (d *decoder) readVarU32(r io.Reader, v *uint32) int {
 	if d.err != nil {
-		return
+		return 0
+	}
+	var n int
+	*v, n, d.err = uvarint(r)
+	d.checkLEB128(n, uvarintLen(uint64(*v)))
+	return n
+}
+
+// bytesLeft returns the number of bytes left in r, or -1 if it is unknown.
+func bytesLeft(r io.Reader) int64 {
+	switch r := r.(type) {
+	case *io.LimitedReader:
+		if n := bytesLeft(r.R); n >= 0 && n < r.N {
+			return n
+		}
+		return r.N
+	case *bytes.Reader:
+		return int64(r.Len())
+	}
+	return -1
+}
+
+// readLength reads the length of a vector or a string, rejecting lengths
+// larger than the bytes left in r as every element takes at least a byte.
+func (d *decoder) readLength(r io.Reader, n *uint32) {
+	d.readVarU32(r, n)
+	if left := bytesLeft(r); d.err == nil && left >= 0 && int64(*n) > left {
+		d.err = errLength
 	}
-	*v, _, d.err = uvarint(r)
 }
 
 func (d *decoder) readString(r io.Reader, s *string) {
@@ -74,11 +154,14 @@ func (d *decoder) readString(r io.Reader, s *string) {
 		return
 	}
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
-	var buf = make([]byte, sz)
+	if uint64(cap(d.buf)) < uint64(sz) {
+		d.buf = make([]byte, sz)
+	}
+	buf := d.buf[:sz]
 	d.read(r, buf)
 	*s = string(buf)
 }
@@ -87,7 +170,15 @@ func (d *decoder) read(r io.Reader, buf []byte) {
 	if d.err != nil || len(buf) == 0 {
 		return
 	}
-	_, d.err = r.Read(buf)
+	_, d.err = io.ReadFull(r, buf)
+}
+
+// skip discards the next n bytes of r.
+func (d *decoder) skip(r io.Reader, n int64) {
+	if d.err != nil || n == 0 {
+		return
+	}
+	_, d.err = io.CopyN(ioutil.Discard, r, n)
 }
 
 func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
@@ -103,17 +194,19 @@ func (d *decoder) readHeader(r io.Reader, hdr *ModuleHeader) {
 		d.err = fmt.Errorf("wasm: invalid magic number (%q)", string(hdr.Magic[:]))
 		return
 	}
+	d.off = int64(binary.Size(hdr))
 }
 
 func (d *decoder) readTypeSection(r io.Reader, s *TypeSection) {
 	var n uint32
-	d.readVarU32(r, &n)
+	d.readLength(r, &n)
 	if d.err != nil {
 		return
 	}
 
 	s.Types = make([]FuncType, int(n))
 	for i := range s.Types {
+		d.mark(r)
 		d.readFuncType(r, &s.Types[i])
 	}
 }
@@ -124,9 +217,15 @@ func (d *decoder) readFuncType(r io.Reader, ft *FuncType) {
 	}
 
 	d.readValueType(r, &ft.form)
+	if d.err == nil && ft.form != ValueFunc {
+		var buf bytes.Buffer
+		d.skipGCType(io.TeeReader(r, &buf), ft.form)
+		ft.gc = buf.Bytes()
+		return
+	}
 
 	var params uint32
-	d.readVarU32(r, &params)
+	d.readLength(r, &params)
 	if d.err != nil {
 		return
 	}
@@ -136,7 +235,7 @@ func (d *decoder) readFuncType(r io.Reader, ft *FuncType) {
 	}
 
 	var results uint32
-	d.readVarU32(r, &results)
+	d.readLength(r, &results)
 	if d.err != nil {
 		return
 	}
@@ -146,6 +245,71 @@ func (d *decoder) readFuncType(r io.Reader, ft *FuncType) {
 	}
 }
 
+// skipGCType reads the encoding of a GC proposal type following its form.
+func (d *decoder) skipGCType(r io.Reader, form ValueType) {
+	var n uint32
+	switch form {
+	case ValueRec:
+		d.readVarU32(r, &n)
+		for i := uint32(0); i < n && d.err == nil; i++ {
+			var sub ValueType
+			d.readValueType(r, &sub)
+			if sub == ValueRec {
+				d.err = errMalform
+			}
+			d.skipGCType(r, sub)
+		}
+	case ValueSub, ValueSubFinal:
+		d.readVarU32(r, &n)
+		for i := uint32(0); i < n && d.err == nil; i++ {
+			var super uint32
+			d.readVarU32(r, &super)
+		}
+		var comp ValueType
+		d.readValueType(r, &comp)
+		if comp == ValueRec || comp == ValueSub || comp == ValueSubFinal {
+			d.err = errMalform
+		}
+		d.skipGCType(r, comp)
+	case ValueStruct:
+		d.readVarU32(r, &n)
+		for i := uint32(0); i < n && d.err == nil; i++ {
+			d.skipFieldType(r)
+		}
+	case ValueArray:
+		d.skipFieldType(r)
+	case ValueFunc:
+		for j := 0; j < 2; j++ {
+			d.readVarU32(r, &n)
+			for i := uint32(0); i < n && d.err == nil; i++ {
+				d.skipRefValueType(r)
+			}
+		}
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("wasm: invalid type form (%d)", form)
+		}
+	}
+}
+
+// skipFieldType reads a storage type and its mutability.
+func (d *decoder) skipFieldType(r io.Reader) {
+	d.skipRefValueType(r)
+	var mut uint32
+	d.readVarU1(r, &mut)
+}
+
+// skipRefValueType reads a value type which may be a reference to a heap
+// type: 0x64 (ref ht) or 0x63 (ref null ht).
+func (d *decoder) skipRefValueType(r io.Reader) {
+	var vt ValueType
+	d.readValueType(r, &vt)
+	if vt == -0x1c || vt == -0x1d {
+		var ht int64
+		d.readVarI64(r, &ht)
+	}
+}
+
 func (d *decoder) readValueType(r io.Reader, vt *ValueType) {
 	if d.err != nil {
 		return
@@ -158,13 +322,14 @@ func (d *decoder) readValueType(r io.Reader, vt *ValueType) {
 
 func (d *decoder) readImportSection(r io.Reader, s *ImportSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.Imports = make([]ImportEntry, int(sz))
 	for i := range s.Imports {
+		d.mark(r)
 		d.readImportEntry(r, &s.Imports[i])
 	}
 }
@@ -200,7 +365,7 @@ func (d *decoder) readImportEntry(r io.Reader, ie *ImportEntry) {
 		ie.Typ = gt
 
 	default:
-		log.Printf("module=%q field=%q\n", ie.Module, ie.Field)
+		logf("module=%q field=%q\n", ie.Module, ie.Field)
 		d.err = fmt.Errorf("wasm: invalid ExternalKind (%d)", byte(ie.Kind))
 	}
 }
@@ -210,9 +375,9 @@ func (d *decoder) readExternalKind(r io.Reader, ek *ExternalKind) {
 		return
 	}
 
-	var v [1]byte
-	d.read(r, v[:])
-	*ek = ExternalKind(v[0])
+	var v byte
+	v, d.err = readByte(r)
+	*ek = ExternalKind(v)
 }
 
 func (d *decoder) readTableType(r io.Reader, tt *TableType) {
@@ -240,12 +405,23 @@ func (d *decoder) readResizableLimits(r io.Reader, tl *ResizableLimits) {
 	}
 
 	d.readVarU32(r, &tl.Flags)
-	d.readVarU32(r, &tl.Initial)
+	d.readLimit(r, tl.Memory64(), &tl.Initial)
 	if (tl.Flags & 0x1) != 0 {
-		d.readVarU32(r, &tl.Maximum)
+		d.readLimit(r, tl.Memory64(), &tl.Maximum)
 	}
 }
 
+// readLimit reads a limit, a varuint64 for a 64-bit memory.
+func (d *decoder) readLimit(r io.Reader, is64 bool, v *uint64) {
+	if !is64 {
+		var v32 uint32
+		d.readVarU32(r, &v32)
+		*v = uint64(v32)
+		return
+	}
+	d.readVarU64(r, v)
+}
+
 func (d *decoder) readMemoryType(r io.Reader, mt *MemoryType) {
 	if d.err != nil {
 		return
@@ -267,26 +443,32 @@ func (d *decoder) readGlobalType(r io.Reader, gt *GlobalType) {
 
 func (d *decoder) readFunctionSection(r io.Reader, s *FunctionSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
+	d.checkLimit("functions", uint64(sz), d.opts.Limits.MaxFunctions)
+	if d.err != nil {
+		return
+	}
 	s.Types = make([]uint32, int(sz))
 	for i := range s.Types {
+		d.mark(r)
 		d.readVarU32(r, &s.Types[i])
 	}
 }
 
 func (d *decoder) readExportSection(r io.Reader, s *ExportSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.Exports = make([]ExportEntry, int(sz))
 	for i := range s.Exports {
+		d.mark(r)
 		d.readExportEntry(r, &s.Exports[i])
 	}
 }
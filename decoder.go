@@ -5,25 +5,199 @@
 package wasm
 
 import (
-	//"bytes"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"math"
 	"os"
+	"sync"
 )
 
+// Options controls optional decoder behaviour.
+type Options struct {
+	Strict   bool // reject non-minimal (over-long) LEB128 encodings
+	SkipCode bool // skip the function bodies of the code section
+	KeepRaw  bool // keep the encoded bytes of every section in its Raw field
+	Limits   DecodeLimits
+
+	// StrictTrailing reports the bytes left unread at the end of a section
+	// as an error, instead of logging and skipping them.
+	StrictTrailing bool
+}
+
+// DecodeLimits bounds the resources used to decode a module,
+// a zero field is no limit except for MaxLocals and MaxModuleBytes.
+type DecodeLimits struct {
+	MaxSections  int // number of sections
+	MaxFunctions int // entries of the function and code sections
+	MaxLocals    int // locals declared by a function body, see DefaultMaxLocals
+	MaxDataBytes int // total size of the data segments
+
+	// MaxModuleBytes bounds the size of a module read by OpenCompressedWith
+	// once decompressed, see DefaultMaxModuleBytes.
+	MaxModuleBytes int
+}
+
+// DefaultMaxLocals is the number of locals a function body may declare when
+// DecodeLimits.MaxLocals is zero, as in other engines. A negative MaxLocals
+// is no limit.
+const DefaultMaxLocals = 50000
+
+// DefaultMaxModuleBytes is the size of a decompressed module when
+// DecodeLimits.MaxModuleBytes is zero. A negative MaxModuleBytes is no limit.
+const DefaultMaxModuleBytes = 1 << 30
+
+// LimitError reports a module exceeding one of the DecodeLimits.
+type LimitError struct {
+	Limit string // "sections", "functions", "locals", "data bytes", "module bytes", "imports" or "code bytes"
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("wasm: decode limit exceeded: more than %d %s", e.Max, e.Limit)
+}
+
+// SectionDecoder decodes the body of size bytes of a section read from r.
+type SectionDecoder func(r io.Reader, size uint32) (Section, error)
+
+var (
+	sectionDecodersMu sync.RWMutex
+	sectionDecoders   = map[SectionID]SectionDecoder{}
+)
+
+// RegisterSectionDecoder registers the decoder of the sections with the given
+// id, consulted for the ids the package does not know. The body of a section
+// not entirely read by fn is skipped.
+func RegisterSectionDecoder(id SectionID, fn SectionDecoder) {
+	sectionDecodersMu.Lock()
+	sectionDecoders[id] = fn
+	sectionDecodersMu.Unlock()
+}
+
+func sectionDecoder(id SectionID) SectionDecoder {
+	sectionDecodersMu.RLock()
+	defer sectionDecodersMu.RUnlock()
+	return sectionDecoders[id]
+}
+
 func Open(name string) (Module, error) {
+	return OpenWith(name, Options{})
+}
+
+// OpenWith decodes the module file name with the given options.
+func OpenWith(name string, opts Options) (Module, error) {
 	f, err := os.Open(name)
 	if err != nil {
 		return Module{}, err
 	}
 	defer f.Close()
 
-	dec := decoder{r: f}
+	dec := decoder{r: f, opts: opts}
+	return dec.readModule()
+}
+
+// OpenCompressed decodes the module file name, which may be compressed with
+// gzip. Files without the gzip magic number are decoded as is.
+func OpenCompressed(name string) (Module, error) {
+	return OpenCompressedWith(name, Options{})
+}
+
+// OpenCompressedWith is like OpenCompressed with the given options. The
+// decompressed module is bounded by opts.Limits.MaxModuleBytes.
+func OpenCompressedWith(name string, opts Options) (Module, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Module{}, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return Module{}, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	max := opts.Limits.MaxModuleBytes
+	if max == 0 {
+		max = DefaultMaxModuleBytes
+	}
+	if max > 0 {
+		// read one more byte to tell a module of max bytes from a larger one
+		r = io.LimitReader(r, int64(max)+1)
+	}
+	// gzip.Reader may return short reads, decode from memory
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Module{}, err
+	}
+	if max > 0 && len(b) > max {
+		return Module{}, &LimitError{Limit: "module bytes", Max: max}
+	}
+	return ParseWith(b, opts)
+}
+
+// Parse decodes a module from b.
+func Parse(b []byte) (Module, error) {
+	return ParseWith(b, Options{})
+}
+
+// ParseStrict decodes a module from b, rejecting non-minimal LEB128
+// encodings which are otherwise accepted, such as the section sizes padded
+// to 5 bytes by linkers.
+func ParseStrict(b []byte) (Module, error) {
+	return ParseWith(b, Options{Strict: true})
+}
+
+// ParseWith decodes a module from b with the given options.
+func ParseWith(b []byte, opts Options) (Module, error) {
+	dec := decoder{r: bytes.NewReader(b), opts: opts}
 	return dec.readModule()
 }
 
+// ParseExports decodes the export section of the module b, the other
+// sections are skipped by their declared sizes and not decoded. It returns
+// no exports if the module has no export section.
+func ParseExports(b []byte) ([]ExportEntry, error) {
+	r := bytes.NewReader(b)
+	d := decoder{r: r}
+	var hdr ModuleHeader
+	d.readHeader(r, &hdr)
+	for d.err == nil {
+		var id, sz uint32
+		d.readVarU7(r, &id)
+		if d.err == io.EOF {
+			return nil, nil
+		}
+		d.readVarU32(r, &sz)
+		if d.err != nil {
+			break
+		}
+		if int64(sz) > int64(r.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if SectionID(id) == ExportID {
+			var s ExportSection
+			d.readExportSection(&io.LimitedReader{R: r, N: int64(sz)}, &s)
+			if d.err == io.EOF {
+				d.err = io.ErrUnexpectedEOF
+			}
+			return s.Exports, d.err
+		}
+		r.Seek(int64(sz), io.SeekCurrent)
+	}
+	if d.err == io.EOF {
+		d.err = io.ErrUnexpectedEOF
+	}
+	return nil, d.err
+}
+
 func (d *decoder) readModule() (Module, error) {
 	var (
 		m   Module
@@ -37,12 +211,106 @@ func (d *decoder) readModule() (Module, error) {
 	}
 	for {
 		s := d.readSection()
-		if s == nil {
+		if s == nil || d.err != nil {
 			return m, d.err
 		}
 		m.Sections = append(m.Sections, s)
+		d.checkLimit("sections", uint64(len(m.Sections)), d.opts.Limits.MaxSections)
+		if d.err != nil {
+			return m, d.err
+		}
 	}
-	return m, d.err
+}
+
+// Decoder decodes modules read from an io.Reader through a buffer. It can be
+// reused for many modules with Reset, sharing its buffers between them.
+type Decoder struct {
+	Options Options // options of the modules decoded after a Reset
+
+	d  decoder
+	br *bufio.Reader
+}
+
+// NewDecoder returns a decoder of the module read from r.
+func NewDecoder(r io.Reader, opts Options) *Decoder {
+	dec := &Decoder{Options: opts}
+	dec.Reset(r)
+	return dec
+}
+
+// Reset discards the state of the decoder, which then reads the next module
+// from r.
+func (dec *Decoder) Reset(r io.Reader) {
+	if dec.br == nil {
+		dec.br = bufio.NewReader(r)
+	} else {
+		dec.br.Reset(r)
+	}
+	dec.d = decoder{r: dec.br, opts: dec.Options, buf: dec.d.buf}
+}
+
+// ReadModule decodes the module, reading up to its end.
+func (dec *Decoder) ReadModule() (Module, error) {
+	if dec.br == nil {
+		return Module{}, io.EOF
+	}
+	return dec.d.readModule()
+}
+
+// SectionReader decodes the sections of a module one at a time.
+type SectionReader struct {
+	Header ModuleHeader
+	d      decoder
+	r      *countingReader
+	n      int // sections read
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// NewSectionReader reads the module header from r and returns a reader of
+// the sections which follow it.
+func NewSectionReader(r io.Reader, opts Options) (*SectionReader, error) {
+	cr := &countingReader{r: r}
+	sr := &SectionReader{d: decoder{r: cr, opts: opts}, r: cr}
+	sr.d.readHeader(cr, &sr.Header)
+	if sr.d.err != nil {
+		return nil, sr.d.err
+	}
+	return sr, nil
+}
+
+// Next decodes the next section, it returns io.EOF at the end of the module.
+func (sr *SectionReader) Next() (Section, error) {
+	if sr.d.err != nil {
+		return nil, sr.d.err
+	}
+	s := sr.d.readSection()
+	if sr.d.err != nil {
+		return nil, sr.d.err
+	}
+	if s == nil {
+		return nil, io.EOF
+	}
+	sr.n++
+	sr.d.checkLimit("sections", uint64(sr.n), sr.d.opts.Limits.MaxSections)
+	return s, sr.d.err
+}
+
+// Offset returns the number of bytes consumed from the underlying reader,
+// the header included. Between calls to Next it is the offset of the next
+// section in the module.
+func (sr *SectionReader) Offset() int64 {
+	return sr.r.n
 }
 
 func (d *decoder) readSection() Section {
@@ -52,38 +320,70 @@ func (d *decoder) readSection() Section {
 		sec Section
 	)
 
-	d.readVarU7(d.r, &id)
+	d.overlong = false
+	d.start, d.size, d.entries = d.off, 0, nil
+	var hdr bytes.Buffer
+	src := d.r
+	if d.opts.KeepRaw {
+		src = io.TeeReader(d.r, &hdr)
+	}
+	d.readVarU7(src, &id)
 	if d.err != nil {
 		if d.err == io.EOF {
+			// clean end of the module, at a section boundary
 			d.err = nil
 		}
 		return nil
 	}
-	d.readVarU32(d.r, &sz)
+	// past the section id, the module must not end before the section does
+	defer func() {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+	}()
+	off := d.off + 1 + int64(d.readVarU32(src, &sz))
+	if left := bytesLeft(d.r); d.err == nil && left >= 0 && int64(sz) > left {
+		d.err = errLength
+	}
 	if d.err != nil {
 		return nil
 	}
+	d.off = off + int64(sz)
+	d.size = int(sz)
 
 	r := &io.LimitedReader{R: d.r, N: int64(sz)}
+	var raw []byte
+	if d.opts.KeepRaw {
+		payload, err := ioutil.ReadAll(r)
+		if err == nil && len(payload) != int(sz) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			d.err = err
+			return nil
+		}
+		raw = append(hdr.Bytes(), payload...)
+		r = &io.LimitedReader{R: bytes.NewReader(payload), N: int64(sz)}
+	}
 	switch SectionID(id) {
 	case UnknownID:
 		var s NameSection
 		d.readString(r, &s.Name)
-		s.Size = int(sz)
 		// if s.Name == "name" could readNameSection
 		if s.Name == "name" {
 			d.readNameSection(r, &s)
 		} else {
-			buf := make([]byte, r.N)
-			d.read(r, buf)
+			s.Payload = make([]byte, r.N)
+			d.read(r, s.Payload)
 		}
-		// fmt.Printf("--- name: %q, size: %d\n", s.Name, s.Size)
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case TypeID:
 		var s TypeSection
 		d.readTypeSection(r, &s)
 		// fmt.Printf("--- types: %d\n", len(s.Types))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case ImportID:
@@ -95,24 +395,28 @@ func (d *decoder) readSection() Section {
 				fmt.Printf("    entry[%d]: %q|%q|%s\n", ii, imp.Module, imp.Field, imp.Kind)
 			}
 		*/
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case FunctionID:
 		var s FunctionSection
 		d.readFunctionSection(r, &s)
 		// fmt.Printf("--- functions: %d\n", len(s.types))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case TableID:
 		var s TableSection
 		d.readTableSection(r, &s)
 		// fmt.Printf("--- tables: %d\n", len(s.tables))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case MemoryID:
 		var s MemorySection
 		d.readMemorySection(r, &s)
 		// fmt.Printf("--- memories: %d\n", len(s.memories))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case GlobalID:
@@ -125,53 +429,92 @@ func (d *decoder) readSection() Section {
 					ge.Type.ContentType, ge.Type.Mutability, ge.Init.Value)
 			}
 		*/
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case ExportID:
 		var s ExportSection
 		d.readExportSection(r, &s)
 		// fmt.Printf("--- exports: %d\n", len(s.Exports))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case StartID:
 		var s StartSection
 		d.readStartSection(r, &s)
 		// fmt.Printf("--- start: 0x%x\n", s.Index)
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case ElementID:
 		var s ElementSection
 		d.readElementSection(r, &s)
 		// fmt.Printf("--- elements: %d\n", len(s.elements))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case CodeID:
 		var s CodeSection
-		d.readCodeSection(r, &s)
+		if d.opts.SkipCode {
+			s.Skipped = int(sz)
+			d.skip(r, r.N)
+			s.rawSection = d.raw(raw)
+			sec = s
+			break
+		}
+		d.readCodeSection(r, &s, off)
 		// fmt.Printf("--- func-bodies: %d\n", len(s.Bodies))
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	case DataID:
 		var s DataSection
 		d.readDataSection(r, &s)
 		// fmt.Printf("--- data-segments: %d\n", len(s.segments))
+		s.rawSection = d.raw(raw)
+		sec = s
+
+	case TagID:
+		var s TagSection
+		d.readTagSection(r, &s)
+		s.rawSection = d.raw(raw)
+		sec = s
+
+	case DataCountID:
+		var s DataCountSection
+		d.readVarU32(r, &s.Count)
+		s.rawSection = d.raw(raw)
 		sec = s
 
 	default:
-		log.Printf("wasm: invalid section ID(%d)\n", id)
+		if fn := sectionDecoder(SectionID(id)); fn != nil {
+			sec, d.err = fn(r, sz)
+			if sec == nil && d.err == nil {
+				d.err = fmt.Errorf("wasm: no section decoded for section ID(%d)", id)
+			}
+			break
+		}
+		logf("wasm: invalid section ID(%d)\n", id)
 		d.err = fmt.Errorf("wasm: invalid section ID")
 
 	}
 
+	if r.N != 0 && d.err == nil && d.opts.StrictTrailing {
+		d.err = fmt.Errorf("%w: %d bytes in the %s section", errTrailing, r.N, SectionID(id))
+	}
 	if r.N != 0 {
-		log.Printf("wasm: N=%d bytes unread! (section=%d)\n", r.N, sec.ID())
-		buf := make([]byte, r.N)
-		d.read(r, buf)
+		logf("wasm: N=%d bytes unread! (section=%d)\n", r.N, id)
+		d.skip(r, r.N)
 	}
 
 	return sec
 }
 
+// raw returns the rawSection of a section just decoded from the bytes b.
+func (d *decoder) raw(b []byte) rawSection {
+	return rawSection{Raw: b, overlong: d.overlong, off: d.start, entries: d.entries, size: d.size}
+}
+
 func (d *decoder) readNameSection(r io.Reader, s *NameSection) {
 	for {
 		if d.err != nil {
@@ -186,7 +529,7 @@ func (d *decoder) readNameSection(r io.Reader, s *NameSection) {
 			return
 		}
 		var sz uint32
-		d.readVarU32(r, &sz)
+		d.readLength(r, &sz)
 		if d.err != nil {
 			return
 		}
@@ -196,59 +539,66 @@ func (d *decoder) readNameSection(r io.Reader, s *NameSection) {
 			d.readString(rr, &s.ModName)
 			//log.Printf("wasm: got Module name: %s\n", s.ModName)
 		case 1: // FunctionNames
+			// repeated subsections are merged
 			var n uint32
-			d.readVarU32(rr, &n)
-			s.FuncName = make([]FunctionNames, int(n))
-			for i := range s.FuncName {
-				d.readVarU32(rr, &s.FuncName[i].Idx)
-				d.readString(rr, &s.FuncName[i].Name)
+			d.readLength(rr, &n)
+			names := make([]FunctionNames, int(n))
+			for i := range names {
+				d.readVarU32(rr, &names[i].Idx)
+				d.readString(rr, &names[i].Name)
 			}
-		case 2: // Local
+			s.FuncName = append(s.FuncName, names...)
+		default: // Local names and unknown subsections are kept raw
+			sub := NameSubsection{ID: byte(nType), Payload: make([]byte, sz)}
+			d.read(rr, sub.Payload)
+			s.Subsections = append(s.Subsections, sub)
 		}
 		if rr.N > 0 {
-			log.Printf("wasm: NameSection N=%d/%d bytes unread! (NameType=%d)\n",
+			logf("wasm: NameSection N=%d/%d bytes unread! (NameType=%d)\n",
 				rr.N, sz, nType)
-			buf := make([]byte, rr.N)
-			d.read(rr, buf)
+			d.skip(rr, rr.N)
 		}
 	}
 }
 
 func (d *decoder) readTableSection(r io.Reader, s *TableSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.tables = make([]TableType, int(sz))
 	for i := range s.tables {
+		d.mark(r)
 		d.readTableType(r, &s.tables[i])
 	}
 }
 
 func (d *decoder) readMemorySection(r io.Reader, s *MemorySection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.memories = make([]MemoryType, int(sz))
 	for i := range s.memories {
+		d.mark(r)
 		d.readMemoryType(r, &s.memories[i])
 	}
 }
 
 func (d *decoder) readGlobalSection(r io.Reader, s *GlobalSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.globals = make([]GlobalVariable, int(sz))
 	for i := range s.globals {
+		d.mark(r)
 		d.readGlobalVariable(r, &s.globals[i])
 	}
 }
@@ -269,34 +619,53 @@ func (d *decoder) readInitExpr(r io.Reader, ie *InitExpr) {
 		return
 	}
 
-	var err error
-	var n int
 	var buf [1]byte
-	n, err = r.Read(buf[:])
-	if err != nil || n <= 0 {
-		return
-	}
-	switch Opcode(buf[0]) {
+	d.read(r, buf[:])
+	ie.Op = Opcode(buf[0])
+	switch ie.Op {
 	case Op_i32_const:
 		fallthrough
 	case Op_i64_const:
 		d.readVarI64(r, &ie.Value)
 	case Op_f32_const:
-		fallthrough
+		var bits [4]byte
+		d.read(r, bits[:])
+		ie.Value = int64(order.Uint32(bits[:]))
 	case Op_f64_const:
-		d.readVarI64(r, &ie.Value)
+		var bits [8]byte
+		d.read(r, bits[:])
+		ie.Value = int64(order.Uint64(bits[:]))
+	case Op_get_global:
+		var idx uint32
+		d.readVarU32(r, &idx)
+		ie.Value = int64(idx)
+	case Op_simd_prefix:
+		var sub uint32
+		d.readVarU32(r, &sub)
+		if d.err == nil && sub != Simd_v128_const {
+			d.err = errInvOp
+		}
+		d.read(r, ie.V128[:])
+	case Op_ref_null:
+		var rt int32
+		d.readVarI7(r, &rt)
+		ie.Value = int64(rt)
+	case Op_ref_func:
+		var idx uint32
+		d.readVarU32(r, &idx)
+		ie.Value = int64(idx)
 	default: // error
-		d.err = errInvOp
-		log.Printf("wasm: invalid Opcode for init_expr %x)\n", buf[0])
+		if d.err == nil {
+			d.err = errInvOp
+			logf("wasm: invalid Opcode for init_expr %x)\n", buf[0])
+		}
 	}
-	n, err = r.Read(buf[:])
-	if err != nil || n <= 0 {
-		d.err = err
-		return
+	d.read(r, buf[:])
+	if d.err == io.EOF {
+		// the expression is truncated
+		d.err = io.ErrUnexpectedEOF
 	}
-	v := buf[0]
-	if v != Op_end {
-		// error
+	if d.err == nil && buf[0] != Op_end {
 		d.err = errOpEnd
 	}
 }
@@ -311,13 +680,14 @@ func (d *decoder) readStartSection(r io.Reader, s *StartSection) {
 
 func (d *decoder) readElementSection(r io.Reader, s *ElementSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.elements = make([]ElemSegment, int(sz))
 	for i := range s.elements {
+		d.mark(r)
 		d.readElemSegment(r, &s.elements[i])
 	}
 }
@@ -327,26 +697,69 @@ func (d *decoder) readElemSegment(r io.Reader, es *ElemSegment) {
 		return
 	}
 
-	d.readVarU32(r, &es.Index)
-	d.readInitExpr(r, &es.Offset)
+	d.readVarU32(r, &es.Flags)
+	if d.err == nil && es.Flags > 7 {
+		d.err = fmt.Errorf("wasm: invalid element segment flags %d", es.Flags)
+		return
+	}
+	if es.Flags&0x3 == 0x2 {
+		d.readVarU32(r, &es.Index)
+	}
+	if es.Flags&0x1 == 0 {
+		d.readInitExpr(r, &es.Offset)
+	}
+	if es.Flags&0x3 != 0 {
+		if es.Flags&0x4 == 0 {
+			// elemkind, 0x00 for funcref
+			var kind uint32
+			d.readVarU7(r, &kind)
+			if d.err == nil && kind != 0 {
+				d.err = fmt.Errorf("wasm: invalid element kind %d", kind)
+			}
+			es.Type = ElemFuncRef
+		} else {
+			var et int32
+			d.readVarI7(r, &et)
+			es.Type = ElemType(et)
+		}
+	}
 
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
+	if d.err != nil {
+		return
+	}
+	if es.Flags&0x4 != 0 {
+		es.Exprs = make([]InitExpr, int(sz))
+		for i := range es.Exprs {
+			d.readInitExpr(r, &es.Exprs[i])
+		}
+		return
+	}
 	es.Elems = make([]uint32, int(sz))
 	for i := range es.Elems {
 		d.readVarU32(r, &es.Elems[i])
 	}
 }
 
-func (d *decoder) readCodeSection(r io.Reader, s *CodeSection) {
+// readCodeSection reads the code section whose payload is at offset off in
+// the module.
+func (d *decoder) readCodeSection(r *io.LimitedReader, s *CodeSection, off int64) {
+	start := r.N
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
+	d.checkLimit("functions", uint64(sz), d.opts.Limits.MaxFunctions)
+	if d.err != nil {
+		return
+	}
 	s.Bodies = make([]FunctionBody, int(sz))
+	s.offsets = make([]int64, int(sz))
 	for i := range s.Bodies {
+		s.offsets[i] = off + start - r.N
 		d.readFunctionBody(r, &s.Bodies[i])
 	}
 }
@@ -357,18 +770,40 @@ func (d *decoder) readFunctionBody(r io.Reader, fb *FunctionBody) {
 		return
 	}
 
-	r = io.LimitReader(r, int64(fb.BodySize))
+	lr := &io.LimitedReader{R: r, N: int64(fb.BodySize)}
 	var locals uint32
-	d.readVarU32(r, &locals)
+	d.readVarU32(lr, &locals)
+	if d.err != nil {
+		return
+	}
+	// a local entry takes at least 2 bytes
+	if int64(locals)*2 > lr.N {
+		d.err = errLocals
+		return
+	}
+	max := d.opts.Limits.MaxLocals
+	if max == 0 {
+		max = DefaultMaxLocals
+	}
+	d.checkLimit("locals", uint64(locals), max)
 	if d.err != nil {
 		return
 	}
 	fb.Locals = make([]LocalEntry, int(locals))
+	var n uint64
 	for i := range fb.Locals {
-		d.readLocalEntry(r, &fb.Locals[i])
+		d.readLocalEntry(lr, &fb.Locals[i])
+		n += uint64(fb.Locals[i].Count)
+		if d.err == nil && n > math.MaxUint32 {
+			d.err = errLocals
+		}
+		d.checkLimit("locals", n, max)
+	}
+	if d.err != nil {
+		return
 	}
 
-	fb.Code, d.err = ioutil.ReadAll(r)
+	fb.Code, d.err = ioutil.ReadAll(lr)
 }
 
 func (d *decoder) readLocalEntry(r io.Reader, le *LocalEntry) {
@@ -380,15 +815,33 @@ func (d *decoder) readLocalEntry(r io.Reader, le *LocalEntry) {
 	d.readValueType(r, &le.Type)
 }
 
+func (d *decoder) readTagSection(r io.Reader, s *TagSection) {
+	var sz uint32
+	d.readLength(r, &sz)
+	if d.err != nil {
+		return
+	}
+
+	s.Tags = make([]TagType, int(sz))
+	for i := range s.Tags {
+		d.mark(r)
+		var attr [1]byte
+		d.read(r, attr[:])
+		s.Tags[i].Attribute = attr[0]
+		d.readVarU32(r, &s.Tags[i].Type)
+	}
+}
+
 func (d *decoder) readDataSection(r io.Reader, s *DataSection) {
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
 	if d.err != nil {
 		return
 	}
 
 	s.segments = make([]DataSegment, int(sz))
 	for i := range s.segments {
+		d.mark(r)
 		d.readDataSegment(r, &s.segments[i])
 	}
 }
@@ -402,7 +855,12 @@ func (d *decoder) readDataSegment(r io.Reader, ds *DataSegment) {
 	d.readInitExpr(r, &ds.Offset)
 
 	var sz uint32
-	d.readVarU32(r, &sz)
+	d.readLength(r, &sz)
+	d.data += uint64(sz)
+	d.checkLimit("data bytes", d.data, d.opts.Limits.MaxDataBytes)
+	if d.err != nil {
+		return
+	}
 	ds.Data = make([]byte, int(sz))
 	d.read(r, ds.Data)
 }
@@ -0,0 +1,996 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var wasmHeader = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+func TestParseStrict(t *testing.T) {
+	// type section holding a single "(func)" type; the section size is
+	// encoded as a padded 5-byte varuint32.
+	padded := append(append([]byte{}, wasmHeader...),
+		0x01, 0x84, 0x80, 0x80, 0x80, 0x00, 0x01, 0x60, 0x00, 0x00)
+
+	mod, err := Parse(padded)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(mod.Sections) != 1 || mod.Sections[0].ID() != TypeID {
+		t.Fatalf("Parse: got %d sections, want one type section", len(mod.Sections))
+	}
+
+	if _, err := ParseStrict(padded); err != errNonCanonical {
+		t.Errorf("ParseStrict: got err %v, want %v", err, errNonCanonical)
+	}
+
+	mod, err = OpenWith("testdata/hello.wasm", Options{Strict: true})
+	if err != nil {
+		t.Fatalf("OpenWith strict: %v", err)
+	}
+	if len(mod.Sections) != 9 {
+		t.Errorf("OpenWith strict: got %d sections, want 9", len(mod.Sections))
+	}
+}
+
+func TestPaddedSectionSize(t *testing.T) {
+	// LLVM pads the section sizes of object files to 5 bytes so they can be
+	// patched in place.
+	padded := append(append([]byte{}, wasmHeader...),
+		0x01, 0x84, 0x80, 0x80, 0x80, 0x00, 0x01, 0x60, 0x00, 0x00,
+		0x03, 0x82, 0x80, 0x80, 0x80, 0x00, 0x01, 0x00)
+
+	mod, err := ParseWith(padded, Options{StrictTrailing: true})
+	if err != nil {
+		t.Fatalf("ParseWith: %v", err)
+	}
+	if len(mod.Sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(mod.Sections))
+	}
+	for i, want := range []int{4, 2} {
+		if got := mod.Sections[i].Size(); got != want {
+			t.Errorf("section %v: got size %d, want %d", mod.Sections[i].ID(), got, want)
+		}
+	}
+
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{}, wasmHeader...),
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x03, 0x02, 0x01, 0x00)
+	if !bytes.Equal(got, want) {
+		t.Errorf("re-encoded:\ngot  % x\nwant % x", got, want)
+	}
+}
+
+func TestFuncBodyOffset(t *testing.T) {
+	for _, name := range []string{"testdata/hello.wasm", "testdata/object.o"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mod, err := Parse(b)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		code := mod.section(CodeID).(CodeSection)
+		for i, want := range code.Bodies {
+			idx := mod.AbsoluteFuncIndex(i)
+			off, ok := mod.FuncBodyOffset(idx)
+			if !ok {
+				t.Fatalf("%s: no offset for function %d", name, idx)
+			}
+			d := decoder{r: bytes.NewReader(b[off:])}
+			var fb FunctionBody
+			d.readFunctionBody(d.r, &fb)
+			if d.err != nil || fb.BodySize != want.BodySize || !bytes.Equal(fb.Code, want.Code) {
+				t.Errorf("%s: function %d at offset %d: got %+v (err %v), want %+v",
+					name, idx, off, fb, d.err, want)
+			}
+		}
+	}
+
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var main uint32
+	for _, ee := range mod.section(ExportID).(ExportSection).Exports {
+		if ee.Field == "Main" {
+			main = ee.Index
+		}
+	}
+	if off, ok := mod.FuncBodyOffset(main); !ok || off != 0x58 {
+		t.Errorf("main: got offset %#x, %v, want 0x58", off, ok)
+	}
+	if _, ok := mod.FuncBodyOffset(0); ok {
+		t.Errorf("imported function: got an offset")
+	}
+}
+
+func TestParseExports(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// corrupt the code section payload, which must not be decoded
+	code := bytes.Index(b, []byte{0x0a, 0x12, 0x01, 0x10})
+	if code < 0 {
+		t.Fatal("code section not found")
+	}
+	for i := code + 2; i < code+2+0x12; i++ {
+		b[i] = 0xff
+	}
+	if _, err := Parse(b); err == nil {
+		t.Fatal("Parse of the corrupted module succeeded")
+	}
+
+	exports, err := ParseExports(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 2 || exports[0].Field != "memory" || exports[0].Kind != MemoryKind ||
+		exports[1] != (ExportEntry{Field: "Main", Kind: FunctionKind, Index: 1}) {
+		t.Errorf("got exports %+v, want memory and Main", exports)
+	}
+
+	if exports, err := ParseExports(wasmHeader); err != nil || exports != nil {
+		t.Errorf("no export section: got %v, %v, want none", exports, err)
+	}
+	if _, err := ParseExports(b[:code-4]); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestSkipCode(t *testing.T) {
+	mod, err := OpenWith("testdata/hello.wasm", Options{SkipCode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mod.Sections) != 9 {
+		t.Fatalf("got %d sections, want 9", len(mod.Sections))
+	}
+	code, ok := mod.section(CodeID).(CodeSection)
+	if !ok {
+		t.Fatalf("code section missing")
+	}
+	if len(code.Bodies) != 0 || code.Skipped != 0x12 {
+		t.Errorf("got %d bodies, skipped %d, want 0 bodies, skipped 18",
+			len(code.Bodies), code.Skipped)
+	}
+	exp := mod.section(ExportID).(ExportSection)
+	if len(exp.Exports) != 2 {
+		t.Errorf("got %d exports, want 2", len(exp.Exports))
+	}
+	if data := mod.section(DataID).(DataSection); len(data.segments) != 1 {
+		t.Errorf("got %d data segments, want 1", len(data.segments))
+	}
+	if _, err := mod.Bytes(); err != errSkippedCode {
+		t.Errorf("encoding skipped code: got %v, want %v", err, errSkippedCode)
+	}
+}
+
+// largeModule returns the encoding of a module with n function bodies of
+// size bytes each.
+func largeModule(tb testing.TB, n, size int) []byte {
+	code := make([]byte, size)
+	for i := range code {
+		code[i] = byte(Op_nop)
+	}
+	code[size-1] = Op_end
+	mod := Module{
+		Header: ModuleHeader{Magic: magicWASM, Version: 1},
+		Sections: []Section{
+			TypeSection{Types: []FuncType{{form: ValueFunc}}},
+			FunctionSection{Types: make([]uint32, n)},
+			CodeSection{Bodies: make([]FunctionBody, n)},
+		},
+	}
+	bodies := mod.Sections[2].(CodeSection).Bodies
+	for i := range bodies {
+		bodies[i].Code = code
+	}
+	b, err := mod.Bytes()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+func benchmarkParse(b *testing.B, opts Options) {
+	buf := largeModule(b, 1000, 4096)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseWith(buf, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B)         { benchmarkParse(b, Options{}) }
+func BenchmarkParseSkipCode(b *testing.B) { benchmarkParse(b, Options{SkipCode: true}) }
+
+func TestDecoderReset(t *testing.T) {
+	hello, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec Decoder
+	if _, err := dec.ReadModule(); err != io.EOF {
+		t.Errorf("ReadModule before Reset: got %v, want %v", err, io.EOF)
+	}
+	for _, b := range [][]byte{hello, largeModule(t, 3, 16), hello[:20], hello} {
+		want, wantErr := Parse(b)
+		dec.Reset(bytes.NewReader(b))
+		got, err := dec.ReadModule()
+		if (err != nil) != (wantErr != nil) {
+			t.Fatalf("ReadModule: got %v, want %v", err, wantErr)
+		}
+		if err == nil && !got.EqualExact(want) {
+			t.Errorf("ReadModule: got %d sections, want %d", len(got.Sections), len(want.Sections))
+		}
+	}
+}
+
+func benchmarkDecoder(b *testing.B, reuse bool) {
+	mods := make([][]byte, 1000)
+	for i := range mods {
+		mods[i] = largeModule(b, 1+i%4, 16)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dec *Decoder
+		for _, buf := range mods {
+			if dec == nil || !reuse {
+				dec = NewDecoder(bytes.NewReader(buf), Options{})
+			} else {
+				dec.Reset(bytes.NewReader(buf))
+			}
+			mod, err := dec.ReadModule()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := mod.ValidateMVP(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecoderFresh(b *testing.B)  { benchmarkDecoder(b, false) }
+func BenchmarkDecoderReused(b *testing.B) { benchmarkDecoder(b, true) }
+
+func TestTableElemType(t *testing.T) {
+	tests := []struct {
+		elem byte
+		want ElemType
+		str  string
+	}{
+		{0x70, ElemFuncRef, "funcref"},
+		{0x6f, ElemExternRef, "externref"},
+	}
+	for _, tt := range tests {
+		// table section with a single table of tt.elem, initial size 1
+		b := append(append([]byte{}, wasmHeader...), 0x04, 0x04, 0x01, tt.elem, 0x00, 0x01)
+		mod, err := Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := mod.section(TableID).(TableSection)
+		if len(ts.tables) != 1 {
+			t.Fatalf("got %d tables, want 1", len(ts.tables))
+		}
+		et := ts.tables[0].ElemType
+		if et != tt.want || et.String() != tt.str {
+			t.Errorf("ElemType = %d (%s), want %d (%s)", et, et, tt.want, tt.str)
+		}
+		if ts.tables[0].Limits.Initial != 1 {
+			t.Errorf("Initial = %d, want 1", ts.tables[0].Limits.Initial)
+		}
+	}
+}
+
+func TestMemory64(t *testing.T) {
+	// a 64-bit memory of 1 to 1<<33 pages, exported as "memory"
+	b, err := ioutil.ReadFile("testdata/memory64.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, ok := mod.memoryLimits(0)
+	if !ok || !limits.Memory64() || limits.Initial != 1 || limits.Maximum != 1<<33 {
+		t.Fatalf("got limits %+v, want a 64-bit memory of 1 to 1<<33 pages", limits)
+	}
+	if s := limits.String(); s != "{initial 1 max 8589934592 i64}" {
+		t.Errorf("String = %q", s)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("re-encoded:\ngot  % x\nwant % x", got, b)
+	}
+
+	// the limits of a 32-bit memory are not truncated
+	mem := Module{Header: mod.Header, Sections: []Section{MemorySection{memories: []MemoryType{
+		{Limits: ResizableLimits{Initial: 1 << 33}}}}}}
+	if _, err := mem.Bytes(); !errors.Is(err, errEncode) {
+		t.Errorf("32-bit memory of 1<<33 pages: got %v, want %v", err, errEncode)
+	}
+}
+
+func TestTagSection(t *testing.T) {
+	b := append(append([]byte{}, wasmHeader...),
+		0x01, 0x05, 0x01, 0x60, 0x01, 0x7f, 0x00, // type (func (param i32))
+		0x0d, 0x03, 0x01, 0x00, 0x00, // tag section: exception of type 0
+	)
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, ok := mod.section(TagID).(TagSection)
+	if !ok {
+		t.Fatalf("tag section missing")
+	}
+	if len(ts.Tags) != 1 || ts.Tags[0] != (TagType{Attribute: 0, Type: 0}) {
+		t.Errorf("got tags %+v, want one exception tag of type 0", ts.Tags)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	bad := Module{Header: mod.Header, Sections: []Section{mod.Sections[0], TagSection{Tags: []TagType{{Type: 1}}}}}
+	checkValidation(t, bad.ValidateMVP(), TagID, errBadIndex)
+	bad.Sections[1] = TagSection{Tags: []TagType{{Type: 0}}}
+	bad.Sections[0] = TypeSection{Types: []FuncType{{form: ValueFunc, results: []ValueType{ValueI32}}}}
+	checkValidation(t, bad.ValidateMVP(), TagID, errTagType)
+
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("WriteTo:\ngot  %x\nwant %x", got, b)
+	}
+}
+
+func TestVarWidth(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		read func(d *decoder, r io.Reader)
+		err  error
+	}{
+		{"varuint7", []byte{0x7f}, func(d *decoder, r io.Reader) { var v uint32; d.readVarU7(r, &v) }, nil},
+		{"varuint7", []byte{0x81, 0x80, 0x80, 0x00}, func(d *decoder, r io.Reader) { var v uint32; d.readVarU7(r, &v) }, errMalform},
+		{"varuint7", []byte{0x80, 0x01}, func(d *decoder, r io.Reader) { var v uint32; d.readVarU7(r, &v) }, errMalform},
+		{"varuint1", []byte{0x01}, func(d *decoder, r io.Reader) { var v uint32; d.readVarU1(r, &v) }, nil},
+		{"varuint1", []byte{0x02}, func(d *decoder, r io.Reader) { var v uint32; d.readVarU1(r, &v) }, errMalform},
+		{"varuint1", []byte{0x81, 0x00}, func(d *decoder, r io.Reader) { var v uint32; d.readVarU1(r, &v) }, errMalform},
+		{"varint7", []byte{0x40}, func(d *decoder, r io.Reader) { var v int32; d.readVarI7(r, &v) }, nil},
+		{"varint7", []byte{0xff, 0x7f}, func(d *decoder, r io.Reader) { var v int32; d.readVarI7(r, &v) }, errMalform},
+	}
+	for _, tt := range tests {
+		d := decoder{r: bytes.NewReader(tt.in)}
+		tt.read(&d, d.r)
+		if d.err != tt.err {
+			t.Errorf("%s %x: got err %v, want %v", tt.name, tt.in, d.err, tt.err)
+		}
+	}
+
+	// a global whose mutability is not a varuint1
+	b := append(append([]byte{}, wasmHeader...),
+		0x06, 0x06, 0x01, 0x7f, 0x02, 0x41, 0x00, 0x0b)
+	if _, err := Parse(b); err != errMalform {
+		t.Errorf("global mutability 2: got %v, want %v", err, errMalform)
+	}
+}
+
+func TestV128Global(t *testing.T) {
+	b := append(append([]byte{}, wasmHeader...),
+		0x06, 0x16, 0x01, 0x7b, 0x00, // global section: one immutable v128
+		0xfd, 0x0c, // v128.const
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x0b,
+	)
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := mod.section(GlobalID).(GlobalSection)
+	if len(gs.globals) != 1 {
+		t.Fatalf("got %d globals, want 1", len(gs.globals))
+	}
+	g := gs.globals[0]
+	if g.Type.ContentType != ValueV128 || g.Type.String() != "v128" {
+		t.Errorf("global type = %s, want v128", g.Type)
+	}
+	var want [16]byte
+	for i := range want {
+		want[i] = byte(i)
+	}
+	if g.Init.Op != Op_simd_prefix || g.Init.V128 != want {
+		t.Errorf("init = %x %x, want v128.const %x", g.Init.Op, g.Init.V128, want)
+	}
+
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("WriteTo:\ngot  %x\nwant %x", got, b)
+	}
+}
+
+func TestOpenCompressed(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, "hello.wasm.gz")
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{fname, "testdata/hello.wasm"} {
+		mod, err := OpenCompressed(name)
+		if err != nil {
+			t.Fatalf("OpenCompressed %s: %v", name, err)
+		}
+		got, err := mod.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, raw) {
+			t.Errorf("OpenCompressed %s:\ngot  %x\nwant %x", name, got, raw)
+		}
+
+		limits := DecodeLimits{MaxModuleBytes: len(raw)}
+		if _, err := OpenCompressedWith(name, Options{Limits: limits}); err != nil {
+			t.Errorf("OpenCompressedWith %s, %d bytes: %v", name, len(raw), err)
+		}
+		limits.MaxModuleBytes--
+		_, err = OpenCompressedWith(name, Options{Limits: limits})
+		want := &LimitError{Limit: "module bytes", Max: len(raw) - 1}
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("OpenCompressedWith %s, %d bytes: got %v, want %v", name, len(raw)-1, err, want)
+		}
+		_, err = OpenCompressedWith(name, Options{Limits: DecodeLimits{MaxSections: 1}})
+		if le, ok := err.(*LimitError); !ok || le.Limit != "sections" {
+			t.Errorf("OpenCompressedWith %s, 1 section: got %v, want a sections LimitError", name, err)
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	hello, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locals := codeModule(0x0b)
+	locals.Sections[3] = CodeSection{Bodies: []FunctionBody{{
+		Locals: []LocalEntry{{Count: 4, Type: ValueI32}, {Count: 4, Type: ValueI64}},
+		Code:   []byte{0x0b},
+	}}}
+	localsBuf, err := locals.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := codeModule(0x0b)
+	data.Sections = append(data.Sections, DataSection{segments: []DataSegment{
+		{Offset: InitExpr{Op: Op_i32_const}, Data: []byte("abc")},
+		{Offset: InitExpr{Op: Op_i32_const, Value: 3}, Data: []byte("def")},
+	}})
+	dataBuf, err := data.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		buf    []byte
+		limits DecodeLimits // limits the module exceeds
+		fits   DecodeLimits // limits the module just fits
+		limit  string
+	}{
+		{hello, DecodeLimits{MaxSections: 8}, DecodeLimits{MaxSections: 9}, "sections"},
+		{largeModule(t, 3, 2), DecodeLimits{MaxFunctions: 2}, DecodeLimits{MaxFunctions: 3}, "functions"},
+		{localsBuf, DecodeLimits{MaxLocals: 7}, DecodeLimits{MaxLocals: 8}, "locals"},
+		{dataBuf, DecodeLimits{MaxDataBytes: 5}, DecodeLimits{MaxDataBytes: 6}, "data bytes"},
+	}
+	for _, tt := range tests {
+		_, err := ParseWith(tt.buf, Options{Limits: tt.limits})
+		le, ok := err.(*LimitError)
+		if !ok || le.Limit != tt.limit {
+			t.Errorf("%+v: got %v, want a %s LimitError", tt.limits, err, tt.limit)
+		}
+		if _, err := ParseWith(tt.buf, Options{Limits: tt.fits}); err != nil {
+			t.Errorf("%+v: %v", tt.fits, err)
+		}
+	}
+}
+
+func TestOversizedLength(t *testing.T) {
+	for _, sec := range [][]byte{
+		// element segment of flags 4 with 2^32-1 expressions
+		{0x09, 0x0a, 0x01, 0x04, 0x41, 0x00, 0x0b, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		// 2^32-1 types
+		{0x01, 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		// import of a module name of 2^32-1 bytes
+		{0x02, 0x06, 0x01, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		// 2^32-1 function bodies
+		{0x0a, 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		// data segment of 2^32-1 bytes
+		{0x0b, 0x0a, 0x01, 0x00, 0x41, 0x00, 0x0b, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		// section larger than the module
+		{0x00, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x01, 'a'},
+	} {
+		b := append(append([]byte{}, wasmHeader...), sec...)
+		if _, err := Parse(b); err != errLength {
+			t.Errorf("%x: got err %v, want %v", sec, err, errLength)
+		}
+		if sec[0] == 0x00 {
+			continue
+		}
+		// the size of the section bounds the lengths of streamed modules
+		sr, err := NewSectionReader(struct{ io.Reader }{bytes.NewReader(b)}, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sr.Next(); err != errLength {
+			t.Errorf("%x: streamed: got err %v, want %v", sec, err, errLength)
+		}
+	}
+}
+
+func TestTruncatedInitExpr(t *testing.T) {
+	for _, sec := range [][]byte{
+		{0x06, 0x03, 0x01, 0x7f, 0x00},             // no opcode
+		{0x06, 0x04, 0x01, 0x7f, 0x00, 0x41},       // i32.const without a value
+		{0x06, 0x05, 0x01, 0x7f, 0x00, 0x41, 0x01}, // no end
+		{0x06, 0x05, 0x01, 0x7c, 0x00, 0x44, 0x00}, // f64.const short of 7 bytes
+	} {
+		b := append(append([]byte{}, wasmHeader...), sec...)
+		if _, err := Parse(b); err != io.ErrUnexpectedEOF {
+			t.Errorf("%x: got err %v, want %v", sec, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestLocalCount(t *testing.T) {
+	noLimit := DecodeLimits{MaxLocals: -1}
+	tests := []struct {
+		body   []byte // function body, without its size
+		limits DecodeLimits
+		err    error
+	}{
+		{[]byte{0x01, 0xd0, 0x86, 0x03, 0x7f, 0x0b}, DecodeLimits{}, nil}, // 50000 i32
+		{[]byte{0x01, 0xd1, 0x86, 0x03, 0x7f, 0x0b}, DecodeLimits{}, // 50001 i32
+			&LimitError{Limit: "locals", Max: DefaultMaxLocals}},
+		{[]byte{0x01, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x7f, 0x0b}, noLimit, nil},                   // 2^32-1 i32
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0x01, 0x7f, 0x0b}, noLimit, errLocals},             // 2^32-1 entries
+		{[]byte{0x02, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x7f, 0x01, 0x7e, 0x0b}, noLimit, errLocals}, // 2^32 locals
+	}
+	for _, tt := range tests {
+		code := append([]byte{0x01, byte(len(tt.body))}, tt.body...)
+		b := append(append([]byte{}, wasmHeader...),
+			0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type ()
+			0x03, 0x02, 0x01, 0x00, // function of type 0
+			0x0a, byte(len(code)))
+		b = append(b, code...)
+		mod, err := ParseWith(b, Options{Limits: tt.limits})
+		if !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("%x: got err %v, want %v", tt.body, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		// the text format does not repeat a long run of locals
+		wat, err := mod.WAT()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wat) > 1000 || !strings.Contains(wat, "(local i32) (;x") {
+			t.Errorf("%x: got WAT of %d bytes:\n%.1000s", tt.body, len(wat), wat)
+		}
+	}
+}
+
+func TestI64Global(t *testing.T) {
+	b := append(append([]byte{}, wasmHeader...),
+		0x06, 0x0a, 0x01, 0x7e, 0x00, // global section: one immutable i64
+		0x42, 0x80, 0x80, 0x80, 0x80, 0x10, // i64.const 0x1_0000_0000
+		0x0b,
+	)
+	mod, err := ParseStrict(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := mod.GlobalInitValue(0); !ok || v != 0x100000000 {
+		t.Errorf("GlobalInitValue(0) = %#x, %v, want 0x100000000, true", v, ok)
+	}
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("WriteTo:\ngot  %x\nwant %x", got, b)
+	}
+}
+
+func TestKeepRaw(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := OpenWith("testdata/hello.wasm", Options{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for _, s := range mod.Sections {
+		rs, ok := s.(interface{ RawBytes() []byte })
+		if !ok {
+			t.Fatalf("section %T has no raw bytes", s)
+		}
+		got = append(got, rs.RawBytes()...)
+	}
+	if !bytes.Equal(got, raw[8:]) {
+		t.Fatalf("raw sections differ from the file:\ngot= %x\nwant=%x", got, raw[8:])
+	}
+
+	mod, err = Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range mod.Sections {
+		if b := s.(interface{ RawBytes() []byte }).RawBytes(); b != nil {
+			t.Fatalf("section %T kept raw bytes without KeepRaw", s)
+		}
+	}
+}
+
+func TestTruncatedSection(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := ParseWith(raw, Options{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	boundary := map[int]bool{8: true}
+	end := 8
+	for _, s := range mod.Sections {
+		end += len(s.(interface{ RawBytes() []byte }).RawBytes())
+		boundary[end] = true
+	}
+
+	for n := 9; n < len(raw); n++ {
+		_, err := Parse(raw[:n])
+		switch {
+		case boundary[n] && err != nil:
+			t.Errorf("module cut at section boundary %d: %v", n, err)
+		case !boundary[n] && err == nil:
+			t.Errorf("module cut mid-section at %d decoded without error", n)
+		case !boundary[n] && err == io.EOF:
+			t.Errorf("module cut mid-section at %d: got io.EOF", n)
+		}
+	}
+}
+
+// countSection is a section of an unknown id holding a single varuint32.
+type countSection struct {
+	count uint32
+}
+
+func (countSection) ID() SectionID { return 100 }
+func (countSection) Size() int     { return 1 }
+
+func TestRegisterSectionDecoder(t *testing.T) {
+	module := append(append([]byte{}, wasmHeader...), 100, 0x02, 0x2a, 0x00)
+	if _, err := Parse(module); err == nil {
+		t.Fatal("unknown section decoded without a registered decoder")
+	}
+
+	RegisterSectionDecoder(100, func(r io.Reader, size uint32) (Section, error) {
+		var s countSection
+		d := decoder{r: r}
+		d.readVarU32(r, &s.count)
+		return s, d.err
+	})
+	t.Cleanup(func() {
+		sectionDecodersMu.Lock()
+		delete(sectionDecoders, 100)
+		sectionDecodersMu.Unlock()
+	})
+	mod, err := Parse(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mod.Sections) != 1 {
+		t.Fatalf("got %d sections, want 1", len(mod.Sections))
+	}
+	if s, ok := mod.Sections[0].(countSection); !ok || s.count != 42 {
+		t.Errorf("got section %#v, want count 42", mod.Sections[0])
+	}
+}
+
+func TestGCTypes(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/gc.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := mod.section(TypeID).(TypeSection)
+	want := []ValueType{ValueStruct, ValueArray, ValueRec, ValueFunc}
+	if len(ts.Types) != len(want) {
+		t.Fatalf("got %d types, want %d", len(ts.Types), len(want))
+	}
+	for i, ft := range ts.Types {
+		if ft.form != want[i] {
+			t.Errorf("type %d: got form %s, want %s", i, ft.form, want[i])
+		}
+	}
+	if p := ts.Types[3].Params(); len(p) != 1 || p[0] != ValueI32 {
+		t.Errorf("func type params = %v, want [i32]", p)
+	}
+
+	var buf bytes.Buffer
+	if _, err := mod.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), raw) {
+		t.Errorf("round trip differs:\ngot= %x\nwant=%x", buf.Bytes(), raw)
+	}
+
+	// a truncated struct type
+	if _, err := Parse(append(append([]byte{}, wasmHeader...), 1, 0x04, 0x01, 0x5f, 0x02, 0x7f)); err == nil {
+		t.Error("truncated struct type decoded without error")
+	}
+
+	// a rec group nested in a rec group
+	nested := append(append([]byte{}, wasmHeader...), 1, 0x05, 0x01, 0x4e, 0x01, 0x4e, 0x00)
+	if _, err := Parse(nested); !errors.Is(err, errMalform) {
+		t.Errorf("nested rec group: got %v, want %v", err, errMalform)
+	}
+
+	// a rec group of two types followed by the function type 2, which is
+	// the entry 1 of the type section
+	rec := append(append([]byte{}, wasmHeader...),
+		1, 0x0b, 0x02, 0x4e, 0x02, 0x5f, 0x00, 0x5e, 0x7f, 0x00, 0x60, 0x00, 0x00,
+		3, 0x02, 0x01, 0x02,
+		10, 0x04, 0x01, 0x02, 0x00, 0x0b,
+	)
+	mod, err = Parse(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValidation(t, mod.ValidateMVP(), TypeID, errRecGroup)
+}
+
+func TestRepeatedFunctionNames(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// name section with two function name subsections
+	b = append(b, 0x00, 0x11, 0x04, 'n', 'a', 'm', 'e',
+		0x01, 0x04, 0x01, 0x00, 0x01, 'a',
+		0x01, 0x04, 0x01, 0x01, 0x01, 'b')
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, want := range []string{"a", "b"} {
+		if name, ok := mod.FunctionName(uint32(idx)); !ok || name != want {
+			t.Errorf("FunctionName(%d) = %q, %v, want %q, true", idx, name, ok, want)
+		}
+	}
+
+	// re-encoded as a single subsection
+	mod = reparse(t, mod)
+	ns := mod.Sections[len(mod.Sections)-1].(NameSection)
+	if len(ns.FuncName) != 2 {
+		t.Errorf("re-encoded names: got %+v", ns.FuncName)
+	}
+}
+
+func TestRefFuncElements(t *testing.T) {
+	// an active segment of flags 4 holding (ref.func 1) (ref.null func), and
+	// a declarative segment of flags 7 holding (ref.func 0)
+	b, err := ioutil.ReadFile("testdata/refelem.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := mod.section(ElementID).(ElementSection)
+	if len(es.elements) != 2 {
+		t.Fatalf("got %d segments, want 2", len(es.elements))
+	}
+	active, decl := es.elements[0], es.elements[1]
+	if active.Flags != 4 || active.Offset.Op != Op_i32_const || len(active.Elems) != 0 ||
+		len(active.Exprs) != 2 ||
+		active.Exprs[0].Op != Op_ref_func || active.Exprs[0].Value != 1 ||
+		active.Exprs[1].Op != Op_ref_null || ElemType(active.Exprs[1].Value) != ElemFuncRef {
+		t.Errorf("active segment: got %+v", active)
+	}
+	if decl.Flags != 7 || decl.Type != ElemFuncRef || len(decl.Exprs) != 1 ||
+		decl.Exprs[0] != (InitExpr{Op: Op_ref_func, Value: 0}) {
+		t.Errorf("declarative segment: got %+v", decl)
+	}
+	if err := mod.ValidateWith(ValidateOptions{AllowReferenceTypes: true}); err != nil {
+		t.Errorf("ValidateWith(AllowReferenceTypes): %v", err)
+	}
+	checkValidation(t, mod.ValidateMVP(), ElementID, errRefElem)
+
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("re-encoded:\ngot  % x\nwant % x", got, b)
+	}
+	wat, err := mod.WAT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"(elem (;0;) (i32.const 0) funcref (ref.func 1) (ref.null func))",
+		"(elem (;1;) declare funcref (ref.func 0))",
+	} {
+		if !strings.Contains(wat, want) {
+			t.Errorf("WAT missing %q:\n%s", want, wat)
+		}
+	}
+}
+
+func TestStrictTrailing(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.addSection(TagSection{Tags: []TagType{{Type: 1}}})
+	mod.addSection(StartSection{Index: 1})
+	mod.addSection(ElementSection{elements: []ElemSegment{
+		{Offset: InitExpr{Op: Op_i32_const}, Elems: []uint32{1}},
+	}})
+	mod.addSection(DataCountSection{Count: 1})
+	mod.SetModuleName("hello")
+	buf, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"testdata/gc.wasm", "testdata/object.o"} {
+		if _, err := OpenWith(name, Options{StrictTrailing: true}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	mod, err = ParseWith(buf, Options{StrictTrailing: true, KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// append a byte to the body of each section in turn
+	for i, s := range mod.Sections {
+		if s.ID() == UnknownID {
+			continue
+		}
+		b := append([]byte{}, wasmHeader...)
+		for j, s := range mod.Sections {
+			raw := s.(interface{ RawBytes() []byte }).RawBytes()
+			if j == i {
+				size := varuint32(s.Size() + 1)
+				b = append(b, byte(s.ID()))
+				b = append(b, size.bytes()...)
+				b = append(b, raw[len(raw)-s.Size():]...)
+				b = append(b, 0)
+			} else {
+				b = append(b, raw...)
+			}
+		}
+		if _, err := ParseWith(b, Options{StrictTrailing: true}); !errors.Is(err, errTrailing) {
+			t.Errorf("%s section: got %v, want %v", s.ID(), err, errTrailing)
+		}
+	}
+}
+
+func TestSectionReaderOffset(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewSectionReader(bytes.NewReader(raw), Options{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off := sr.Offset(); off != 8 {
+		t.Errorf("Offset() after the header = %d, want 8", off)
+	}
+	want := int64(8)
+	for i := 0; i < 2; i++ {
+		s, err := sr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want += int64(len(s.(interface{ RawBytes() []byte }).RawBytes()))
+	}
+	if off := sr.Offset(); off != want {
+		t.Errorf("Offset() after two sections = %d, want %d", off, want)
+	}
+
+	n := 2
+	for {
+		_, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 9 || sr.Offset() != int64(len(raw)) {
+		t.Errorf("read %d sections up to offset %d, want 9 up to %d", n, sr.Offset(), len(raw))
+	}
+}
+
+func TestRefValueTypes(t *testing.T) {
+	// (type (func (param externref) (result funcref)))
+	module := append(append([]byte{}, wasmHeader...), 0x01, 0x06, 0x01, 0x60, 0x01, 0x6f, 0x01, 0x70)
+	mod, err := Parse(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := mod.section(TypeID).(TypeSection).Types[0]
+	if p := ft.Params(); len(p) != 1 || p[0] != ValueExternRef {
+		t.Errorf("params = %v, want [externref]", p)
+	}
+	if got, want := ft.String(), "(func (param externref) (result funcref))"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
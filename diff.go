@@ -0,0 +1,214 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// ChangeKind tells how an entry differs between two modules.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change describes a difference between two modules.
+type Change struct {
+	What   string // "section", "type", "import", "export", "functions" or "data"
+	Kind   ChangeKind
+	Name   string // name of the entry
+	Detail string // the entry, or old -> new for a modification
+}
+
+func (c Change) String() string {
+	s := c.What + " " + c.Kind.String()
+	if c.Name != "" {
+		s += ": " + c.Name
+	}
+	if c.Detail != "" {
+		s += " " + c.Detail
+	}
+	return s
+}
+
+// Diff returns the changes from module a to module b: added and removed
+// sections, types, imports and exports, and the number of functions and
+// bytes of data. Changes are listed in a stable order.
+func Diff(a, b Module) []Change {
+	var changes []Change
+	changes = diffEntries(changes, "section", a.sectionNames(), b.sectionNames())
+	changes = diffEntries(changes, "type", a.typeEntries(), b.typeEntries())
+	changes = diffEntries(changes, "import", a.importEntries(), b.importEntries())
+	changes = diffEntries(changes, "export", a.exportEntries(), b.exportEntries())
+	if na, nb := a.NumFunctions(), b.NumFunctions(); na != nb {
+		changes = append(changes, Change{What: "functions", Kind: Modified,
+			Detail: fmt.Sprintf("%d -> %d", na, nb)})
+	}
+	if na, nb := a.dataSize(), b.dataSize(); na != nb {
+		changes = append(changes, Change{What: "data", Kind: Modified,
+			Detail: fmt.Sprintf("%d -> %d bytes", na, nb)})
+	}
+	return changes
+}
+
+// diffEntries appends the changes between the entries of a and b, keyed by
+// name, sorted by name.
+func diffEntries(changes []Change, what string, a, b map[string]string) []Change {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		va, inA := a[name]
+		vb, inB := b[name]
+		switch {
+		case !inA:
+			changes = append(changes, Change{What: what, Kind: Added, Name: name, Detail: vb})
+		case !inB:
+			changes = append(changes, Change{What: what, Kind: Removed, Name: name, Detail: va})
+		case va != vb:
+			changes = append(changes, Change{What: what, Kind: Modified, Name: name,
+				Detail: va + " -> " + vb})
+		}
+	}
+	return changes
+}
+
+func (m Module) sectionNames() map[string]string {
+	ret := make(map[string]string)
+	for _, s := range m.Sections {
+		if ns, ok := s.(NameSection); ok {
+			ret["custom "+ns.Name] = ""
+		} else {
+			ret[s.ID().String()] = ""
+		}
+	}
+	return ret
+}
+
+func (m Module) typeEntries() map[string]string {
+	ret := make(map[string]string)
+	if s, ok := m.section(TypeID).(TypeSection); ok {
+		for i := range s.Types {
+			ret[fmt.Sprint(i)] = s.Types[i].String()
+		}
+	}
+	return ret
+}
+
+func (m Module) importEntries() map[string]string {
+	mc := m.context()
+	ret := make(map[string]string)
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			ret[imp.Module+"."+imp.Field] = importType(&mc, &imp)
+		}
+	}
+	return ret
+}
+
+func (m Module) exportEntries() map[string]string {
+	ret := make(map[string]string)
+	if s, ok := m.section(ExportID).(ExportSection); ok {
+		for _, exp := range s.Exports {
+			ret[exp.Field] = fmt.Sprintf("%s %d", exp.Kind, exp.Index)
+		}
+	}
+	return ret
+}
+
+func (m Module) dataSize() int {
+	n := 0
+	if s, ok := m.section(DataID).(DataSection); ok {
+		for _, seg := range s.segments {
+			n += len(seg.Data)
+		}
+	}
+	return n
+}
+
+// Equal reports whether m and other have the same header and the same known
+// sections, in the same order and with the same content. Custom sections and
+// the raw bytes kept by Options.KeepRaw are ignored, see EqualExact. Code
+// sections skipped by Options.SkipCode are compared by their raw bytes, or only
+// by their size if those were not kept, so that the result is approximate.
+func (m Module) Equal(other Module) bool {
+	return m.equal(other, false)
+}
+
+// EqualExact is like Equal but also compares the custom sections.
+func (m Module) EqualExact(other Module) bool {
+	return m.equal(other, true)
+}
+
+func (m Module) equal(other Module, custom bool) bool {
+	if m.Header != other.Header {
+		return false
+	}
+	a, b := m.comparedSections(custom), other.comparedSections(custom)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sectionEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Module) comparedSections(custom bool) []Section {
+	var secs []Section
+	for _, s := range m.Sections {
+		if custom || s.ID() != UnknownID {
+			secs = append(secs, s)
+		}
+	}
+	return secs
+}
+
+// sectionEqual compares two sections by their encoded content. A skipped
+// code section is only compared by its raw bytes, or by its size if they
+// were not kept.
+func sectionEqual(a, b Section) bool {
+	if a.ID() != b.ID() {
+		return false
+	}
+	ca, okA := a.(CodeSection)
+	cb, okB := b.(CodeSection)
+	if okA && okB && (ca.Skipped != 0 || cb.Skipped != 0) {
+		if ca.Raw == nil || cb.Raw == nil {
+			return ca.Size() == cb.Size()
+		}
+		return bytes.Equal(ca.Raw, cb.Raw)
+	}
+	var ea, eb encoder
+	ea.writeSectionBody(a)
+	eb.writeSectionBody(b)
+	return ea.err == nil && eb.err == nil && bytes.Equal(ea.buf.Bytes(), eb.buf.Bytes())
+}
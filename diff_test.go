@@ -0,0 +1,122 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Errorf("Diff(a, a) = %v, want no changes", changes)
+	}
+
+	b, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddExport("run", FunctionKind, 1); err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(a, b)
+	if len(changes) != 1 {
+		t.Fatalf("got changes %v, want one", changes)
+	}
+	c := changes[0]
+	if c.What != "export" || c.Kind != Added || c.Name != "run" {
+		t.Errorf("got change %+v, want export run added", c)
+	}
+	if s, want := c.String(), "export added: run func 1"; s != want {
+		t.Errorf("String() = %q, want %q", s, want)
+	}
+
+	changes = Diff(b, a)
+	if len(changes) != 1 || changes[0].Kind != Removed {
+		t.Errorf("Diff(b, a) = %v, want export run removed", changes)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, err := OpenWith("testdata/hello.wasm", Options{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := reparse(t, a)
+	if !a.Equal(clone) || !a.EqualExact(clone) {
+		t.Fatal("module differs from its clone")
+	}
+
+	custom := withCustom(t, "producers", []byte{0})
+	if !a.Equal(custom) {
+		t.Error("Equal compares custom sections")
+	}
+	if a.EqualExact(custom) {
+		t.Error("EqualExact ignores custom sections")
+	}
+
+	if err := clone.AddExport("run", FunctionKind, 1); err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(clone) {
+		t.Error("module equals its clone after an export is added")
+	}
+}
+
+func TestEqualSkipCode(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{SkipCode: true, KeepRaw: true}
+	a, err := ParseWith(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := ParseWith(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(same) {
+		t.Error("skipped code sections with the same raw bytes differ")
+	}
+
+	// a body changed in place keeps the size of the code section
+	raw := a.section(CodeID).(CodeSection).Raw
+	changed := append([]byte{}, b...)
+	changed[bytes.Index(b, raw)+len(raw)-2] ^= 0xff
+	other, err := ParseWith(changed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(other) {
+		t.Error("skipped code sections with different raw bytes are equal")
+	}
+
+	noRaw, err := ParseWith(b, Options{SkipCode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !noRaw.Equal(noRaw) {
+		t.Error("a module with skipped code differs from itself")
+	}
+	// without raw bytes only the sizes are compared
+	if !noRaw.Equal(a) {
+		t.Error("skipped code sections of the same size differ")
+	}
+	other = Module{Header: noRaw.Header, Sections: append([]Section{}, noRaw.Sections...)}
+	pos := other.sectionIndex(CodeID)
+	cs := other.Sections[pos].(CodeSection)
+	cs.Skipped++
+	other.Sections[pos] = cs
+	if noRaw.Equal(other) {
+		t.Error("skipped code sections of different sizes are equal")
+	}
+}
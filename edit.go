@@ -0,0 +1,641 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errNoExport = errors.New("wasm: no such export")
+
+// addSection inserts s after the last known section ordered before it.
+func (m *Module) addSection(s Section) {
+	pos := 0
+	for i, sec := range m.Sections {
+		if id := sec.ID(); id != UnknownID && sectionOrder(id) < sectionOrder(s.ID()) {
+			pos = i + 1
+		}
+	}
+	m.Sections = append(m.Sections, nil)
+	copy(m.Sections[pos+1:], m.Sections[pos:])
+	m.Sections[pos] = s
+}
+
+// setSection replaces the section of the same id as s, or adds s.
+func (m *Module) setSection(s Section) {
+	if pos := m.sectionIndex(s.ID()); pos >= 0 {
+		m.Sections[pos] = s
+	} else {
+		m.addSection(s)
+	}
+}
+
+// sectionIndex returns the position of the first section with the given
+// id in m.Sections, or -1.
+func (m Module) sectionIndex(id SectionID) int {
+	for i, s := range m.Sections {
+		if s.ID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// numEntities returns the size of the index space of kind.
+func (mc *moduleContext) numEntities(kind ExternalKind) int {
+	switch kind {
+	case FunctionKind:
+		return len(mc.funcs)
+	case TableKind:
+		return mc.tables
+	case MemoryKind:
+		return mc.mems
+	case GlobalKind:
+		return len(mc.globals)
+	}
+	return 0
+}
+
+// AddExport exports the entity index of kind as name, adding an export
+// section if the module has none.
+func (m *Module) AddExport(name string, kind ExternalKind, index uint32) error {
+	mc := m.context()
+	if int64(index) >= int64(mc.numEntities(kind)) {
+		return errBadIndex
+	}
+
+	pos := m.sectionIndex(ExportID)
+	if pos < 0 {
+		m.addSection(ExportSection{})
+		pos = m.sectionIndex(ExportID)
+	}
+	es := m.Sections[pos].(ExportSection)
+	for _, ee := range es.Exports {
+		if ee.Field == name {
+			return errDupExport
+		}
+	}
+	exports := make([]ExportEntry, len(es.Exports), len(es.Exports)+1)
+	copy(exports, es.Exports)
+	es.Exports = append(exports, ExportEntry{Field: name, Kind: kind, Index: index})
+	es.size = 0
+	m.Sections[pos] = es
+	return nil
+}
+
+// RenameExport renames the export oldName to newName. The name section names
+// entities by index, it is left unchanged.
+func (m *Module) RenameExport(oldName, newName string) error {
+	pos := m.sectionIndex(ExportID)
+	if pos < 0 {
+		return errNoExport
+	}
+	es := m.Sections[pos].(ExportSection)
+	found := -1
+	for i, ee := range es.Exports {
+		switch ee.Field {
+		case oldName:
+			found = i
+		case newName:
+			return errDupExport
+		}
+	}
+	if found < 0 {
+		return errNoExport
+	}
+	exports := make([]ExportEntry, len(es.Exports))
+	copy(exports, es.Exports)
+	exports[found].Field = newName
+	es.Exports = exports
+	es.size = 0
+	m.Sections[pos] = es
+	return nil
+}
+
+// SetFuncBody replaces the body of the defined function of absolute index
+// idx, its size is computed when the module is encoded.
+func (m *Module) SetFuncBody(idx uint32, body FunctionBody) error {
+	pos := m.sectionIndex(CodeID)
+	def, ok := m.DefinedFuncIndex(idx)
+	if pos < 0 || !ok {
+		return errBadIndex
+	}
+	cs := m.Sections[pos].(CodeSection)
+	if cs.Skipped != 0 {
+		return errSkippedCode
+	}
+	if def >= len(cs.Bodies) {
+		return errBadIndex
+	}
+	bodies := make([]FunctionBody, len(cs.Bodies))
+	copy(bodies, cs.Bodies)
+	bodies[def] = body
+	cs.Bodies = bodies
+	cs.size = 0
+	m.Sections[pos] = cs
+	return nil
+}
+
+// typeIndex returns the index of sig in the type section, appending it to
+// the section if no equal type exists.
+func (m *Module) typeIndex(sig FuncType) uint32 {
+	pos := m.sectionIndex(TypeID)
+	if pos < 0 {
+		m.addSection(TypeSection{})
+		pos = m.sectionIndex(TypeID)
+	}
+	ts := m.Sections[pos].(TypeSection)
+	for i := range ts.Types {
+		if ts.Types[i].equal(&sig) {
+			return uint32(i)
+		}
+	}
+	types := make([]FuncType, len(ts.Types), len(ts.Types)+1)
+	copy(types, ts.Types)
+	ts.Types = append(types, sig)
+	ts.size = 0
+	m.Sections[pos] = ts
+	return uint32(len(ts.Types) - 1)
+}
+
+// rewriteCode returns bodies with every instruction passed to rewrite, which
+// reports whether it changed the instruction. Only the bodies holding a
+// changed instruction are re-encoded.
+func rewriteCode(bodies []FunctionBody, rewrite func(*Instruction) bool) ([]FunctionBody, error) {
+	ret := make([]FunctionBody, len(bodies))
+	for i, fb := range bodies {
+		ret[i] = fb
+		insns, err := fb.Instructions()
+		if err != nil {
+			return nil, err
+		}
+		changed := false
+		for j := range insns {
+			if rewrite(&insns[j]) {
+				changed = true
+			}
+		}
+		if changed {
+			if ret[i].Code, err = EncodeInstructions(insns); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+// remapCalls returns bodies with the target of every call rewritten by fn.
+func remapCalls(bodies []FunctionBody, fn func(uint32) uint32) ([]FunctionBody, error) {
+	return rewriteCode(bodies, func(ins *Instruction) bool {
+		if ins.Op != Op_call {
+			return false
+		}
+		idx := fn(ins.Index)
+		changed := idx != ins.Index
+		ins.Index = idx
+		return changed
+	})
+}
+
+// remapFuncs rewrites every reference to a function index by fn: calls,
+// function exports, the start function, element segments and function names.
+func (m *Module) remapFuncs(fn func(uint32) uint32) error {
+	sections := make([]Section, len(m.Sections))
+	for i, sec := range m.Sections {
+		switch s := sec.(type) {
+		case CodeSection:
+			if s.Skipped != 0 {
+				return errSkippedCode
+			}
+			bodies, err := remapCalls(s.Bodies, fn)
+			if err != nil {
+				return err
+			}
+			s.Bodies = bodies
+			s.size = 0
+			sec = s
+
+		case ExportSection:
+			exports := make([]ExportEntry, len(s.Exports))
+			for j, ee := range s.Exports {
+				if ee.Kind == FunctionKind {
+					ee.Index = fn(ee.Index)
+				}
+				exports[j] = ee
+			}
+			s.Exports = exports
+			s.size = 0
+			sec = s
+
+		case StartSection:
+			s.Index = fn(s.Index)
+			s.size = 0
+			sec = s
+
+		case ElementSection:
+			elements := make([]ElemSegment, len(s.elements))
+			for j, es := range s.elements {
+				elems := make([]uint32, len(es.Elems))
+				for k, idx := range es.Elems {
+					elems[k] = fn(idx)
+				}
+				es.Elems = elems
+				if es.Exprs != nil {
+					exprs := make([]InitExpr, len(es.Exprs))
+					for k, ie := range es.Exprs {
+						if ie.Op == Op_ref_func {
+							ie.Value = int64(fn(uint32(ie.Value)))
+						}
+						exprs[k] = ie
+					}
+					es.Exprs = exprs
+				}
+				elements[j] = es
+			}
+			s.elements = elements
+			s.size = 0
+			sec = s
+
+		case NameSection:
+			if len(s.FuncName) == 0 && len(s.Subsections) == 0 {
+				break
+			}
+			names := make([]FunctionNames, len(s.FuncName))
+			for j, fnam := range s.FuncName {
+				fnam.Idx = fn(fnam.Idx)
+				names[j] = fnam
+			}
+			s.FuncName = names
+			subs := make([]NameSubsection, len(s.Subsections))
+			for j, ss := range s.Subsections {
+				if ss.ID == 2 { // local names
+					var err error
+					if ss.Payload, err = remapLocalNames(ss.Payload, fn); err != nil {
+						return err
+					}
+				}
+				subs[j] = ss
+			}
+			s.Subsections = subs
+			s.size = 0
+			sec = s
+		}
+		sections[i] = sec
+	}
+	m.Sections = sections
+	return nil
+}
+
+// remapLocalNames returns the local names subsection p with its function
+// indices rewritten by fn.
+func remapLocalNames(p []byte, fn func(uint32) uint32) ([]byte, error) {
+	var (
+		d decoder
+		e encoder
+		n uint32
+	)
+	r := bytes.NewReader(p)
+	d.readLength(r, &n)
+	e.writeVarU32(n)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		var idx, count uint32
+		d.readVarU32(r, &idx)
+		d.readLength(r, &count)
+		e.writeVarU32(fn(idx))
+		e.writeVarU32(count)
+		for j := uint32(0); j < count && d.err == nil; j++ {
+			var local uint32
+			var name string
+			d.readVarU32(r, &local)
+			d.readString(r, &name)
+			e.writeVarU32(local)
+			e.writeString(name)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// AddImportFunc imports the host function module.field of signature sig,
+// reusing an equal type entry, and returns its function index. The defined
+// functions are renumbered and all references to them rewritten.
+func (m *Module) AddImportFunc(module, field string, sig FuncType) (uint32, error) {
+	if sig.form == 0 {
+		sig.form = ValueFunc
+	}
+	idx := uint32(m.NumImportedFuncs())
+	err := m.remapFuncs(func(i uint32) uint32 {
+		if i >= idx {
+			return i + 1
+		}
+		return i
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	typ := m.typeIndex(sig)
+	pos := m.sectionIndex(ImportID)
+	if pos < 0 {
+		m.addSection(ImportSection{})
+		pos = m.sectionIndex(ImportID)
+	}
+	is := m.Sections[pos].(ImportSection)
+	imports := make([]ImportEntry, len(is.Imports), len(is.Imports)+1)
+	copy(imports, is.Imports)
+	is.Imports = append(imports, ImportEntry{
+		Module: module,
+		Field:  field,
+		Kind:   FunctionKind,
+		Typ:    typ,
+	})
+	is.size = 0
+	m.Sections[pos] = is
+	return idx, nil
+}
+
+// ExtractFunction returns a standalone module holding the defined function
+// idx and the functions it calls, with the types they use. The imports,
+// tables, memories and globals are kept. The function is exported under
+// its export name, or "main" if it is not exported.
+func (m Module) ExtractFunction(idx uint32) (Module, error) {
+	code, _ := m.section(CodeID).(CodeSection)
+	if code.Skipped != 0 {
+		return Module{}, errSkippedCode
+	}
+	if _, ok := m.DefinedFuncIndex(idx); !ok {
+		return Module{}, errBadIndex
+	}
+	mc := m.context()
+	nImp := uint32(m.NumImportedFuncs())
+
+	// walk the call graph from idx, numbering the functions as found
+	funcs := []uint32{idx}
+	funcMap := map[uint32]uint32{idx: nImp}
+	insns := make([][]Instruction, 0, 1)
+	for i := 0; i < len(funcs); i++ {
+		def, ok := m.DefinedFuncIndex(funcs[i])
+		if !ok || def >= len(code.Bodies) {
+			return Module{}, errBadIndex
+		}
+		body, err := code.Bodies[def].decode(funcs[i])
+		if err != nil {
+			return Module{}, err
+		}
+		for _, ins := range body {
+			if ins.Op != Op_call || ins.Index < nImp {
+				continue
+			}
+			if _, ok := funcMap[ins.Index]; !ok {
+				funcMap[ins.Index] = nImp + uint32(len(funcs))
+				funcs = append(funcs, ins.Index)
+			}
+		}
+		insns = append(insns, body)
+	}
+
+	var types []FuncType
+	typeMap := make(map[uint32]uint32)
+	useType := func(t uint32) (uint32, error) {
+		if n, ok := typeMap[t]; ok {
+			return n, nil
+		}
+		ft := mc.typeAt(t)
+		if ft == nil {
+			return 0, errBadIndex
+		}
+		typeMap[t] = uint32(len(types))
+		types = append(types, *ft)
+		return typeMap[t], nil
+	}
+
+	ret := Module{Header: m.Header}
+	var err error
+	if is, ok := m.section(ImportID).(ImportSection); ok {
+		imports := make([]ImportEntry, len(is.Imports))
+		for i, imp := range is.Imports {
+			if t, ok := imp.Typ.(uint32); ok {
+				if imp.Typ, err = useType(t); err != nil {
+					return Module{}, err
+				}
+			}
+			imports[i] = imp
+		}
+		ret.Sections = append(ret.Sections, ImportSection{Imports: imports})
+	}
+
+	fs := FunctionSection{Types: make([]uint32, len(funcs))}
+	cs := CodeSection{Bodies: make([]FunctionBody, len(funcs))}
+	for i, f := range funcs {
+		if fs.Types[i], err = useType(mc.funcs[f]); err != nil {
+			return Module{}, err
+		}
+		for j := range insns[i] {
+			ins := &insns[i][j]
+			switch ins.Op {
+			case Op_call:
+				if n, ok := funcMap[ins.Index]; ok {
+					ins.Index = n
+				}
+			case Op_call_indirect:
+				if ins.Index, err = useType(ins.Index); err != nil {
+					return Module{}, err
+				}
+			}
+		}
+		def, _ := m.DefinedFuncIndex(f)
+		cs.Bodies[i].Locals = code.Bodies[def].Locals
+		if cs.Bodies[i].Code, err = EncodeInstructions(insns[i]); err != nil {
+			return Module{}, err
+		}
+	}
+	ret.Sections = append(ret.Sections, fs)
+	for _, id := range []SectionID{TableID, MemoryID, GlobalID} {
+		if s := m.section(id); s != nil {
+			ret.Sections = append(ret.Sections, s)
+		}
+	}
+
+	name := "main"
+	if es, ok := m.section(ExportID).(ExportSection); ok {
+		for _, ee := range es.Exports {
+			if ee.Kind == FunctionKind && ee.Index == idx {
+				name = ee.Field
+				break
+			}
+		}
+	}
+	ret.Sections = append(ret.Sections,
+		ExportSection{Exports: []ExportEntry{{Field: name, Kind: FunctionKind, Index: nImp}}},
+		cs)
+	ret.addSection(TypeSection{Types: types})
+	return ret, nil
+}
+
+// UnusedTypes returns the indices of the type section entries referenced by
+// no function, import, call_indirect, block type or tag. It returns nil if
+// the code was skipped or does not decode, the uses are unknown then.
+func (m Module) UnusedTypes() []uint32 {
+	ts, _ := m.section(TypeID).(TypeSection)
+	used := make([]bool, len(ts.Types))
+	use := func(t uint32) {
+		if int64(t) < int64(len(used)) {
+			used[t] = true
+		}
+	}
+	for _, sec := range m.Sections {
+		switch s := sec.(type) {
+		case ImportSection:
+			for _, imp := range s.Imports {
+				if t, ok := imp.Typ.(uint32); ok {
+					use(t)
+				}
+			}
+		case FunctionSection:
+			for _, t := range s.Types {
+				use(t)
+			}
+		case CodeSection:
+			if s.Skipped != 0 {
+				return nil
+			}
+			for _, fb := range s.Bodies {
+				insns, err := fb.Instructions()
+				if err != nil {
+					return nil
+				}
+				for _, ins := range insns {
+					switch {
+					case ins.Op == Op_call_indirect:
+						use(ins.Index)
+					case opImmediate(ins.Op) == immBlock && ins.Block >= 0:
+						use(uint32(ins.Block))
+					}
+				}
+			}
+		case TagSection:
+			for _, tag := range s.Tags {
+				use(tag.Type)
+			}
+		}
+	}
+	var unused []uint32
+	for i, u := range used {
+		if !u {
+			unused = append(unused, uint32(i))
+		}
+	}
+	return unused
+}
+
+// DedupeTypes merges the equal entries of the type section, rewriting the
+// type indices of imports, functions, call_indirect, block types and tags.
+func (m *Module) DedupeTypes() error {
+	pos := m.sectionIndex(TypeID)
+	if pos < 0 {
+		return nil
+	}
+	ts := m.Sections[pos].(TypeSection)
+	var types []FuncType
+	remap := make([]uint32, len(ts.Types))
+	for i := range ts.Types {
+		remap[i] = uint32(len(types))
+		for j := range types {
+			if types[j].equal(&ts.Types[i]) {
+				remap[i] = uint32(j)
+				break
+			}
+		}
+		if remap[i] == uint32(len(types)) {
+			types = append(types, ts.Types[i])
+		}
+	}
+	if len(types) == len(ts.Types) {
+		return nil
+	}
+	err := m.remapTypes(func(i uint32) uint32 {
+		if int64(i) < int64(len(remap)) {
+			return remap[i]
+		}
+		return i
+	})
+	if err != nil {
+		return err
+	}
+	m.Sections[pos] = TypeSection{Types: types}
+	return nil
+}
+
+// remapTypes rewrites every reference to a type index by fn: function
+// imports, the function section, call_indirect, block types and tags.
+func (m *Module) remapTypes(fn func(uint32) uint32) error {
+	sections := make([]Section, len(m.Sections))
+	for i, sec := range m.Sections {
+		switch s := sec.(type) {
+		case ImportSection:
+			imports := make([]ImportEntry, len(s.Imports))
+			for j, imp := range s.Imports {
+				if t, ok := imp.Typ.(uint32); ok {
+					imp.Typ = fn(t)
+				}
+				imports[j] = imp
+			}
+			s.Imports = imports
+			s.size = 0
+			sec = s
+
+		case FunctionSection:
+			types := make([]uint32, len(s.Types))
+			for j, t := range s.Types {
+				types[j] = fn(t)
+			}
+			s.Types = types
+			s.size = 0
+			sec = s
+
+		case CodeSection:
+			if s.Skipped != 0 {
+				return errSkippedCode
+			}
+			bodies, err := rewriteCode(s.Bodies, func(ins *Instruction) bool {
+				switch {
+				case ins.Op == Op_call_indirect:
+					t := fn(ins.Index)
+					changed := t != ins.Index
+					ins.Index = t
+					return changed
+				case opImmediate(ins.Op) == immBlock && ins.Block >= 0:
+					t := BlockType(fn(uint32(ins.Block)))
+					changed := t != ins.Block
+					ins.Block = t
+					return changed
+				}
+				return false
+			})
+			if err != nil {
+				return err
+			}
+			s.Bodies = bodies
+			s.size = 0
+			sec = s
+
+		case TagSection:
+			tags := make([]TagType, len(s.Tags))
+			for j, tag := range s.Tags {
+				tag.Type = fn(tag.Type)
+				tags[j] = tag
+			}
+			s.Tags = tags
+			s.size = 0
+			sec = s
+		}
+		sections[i] = sec
+	}
+	m.Sections = sections
+	return nil
+}
@@ -0,0 +1,319 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"testing"
+)
+
+// reparse encodes and decodes mod.
+func reparse(t *testing.T, mod Module) Module {
+	t.Helper()
+	b, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	return mod
+}
+
+func TestAddExport(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.AddExport("run", FunctionKind, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.AddExport("Main", FunctionKind, 1); err != errDupExport {
+		t.Errorf("duplicate name: got %v, want %v", err, errDupExport)
+	}
+	if err := mod.AddExport("bad", FunctionKind, 2); err != errBadIndex {
+		t.Errorf("out of range function: got %v, want %v", err, errBadIndex)
+	}
+	if err := mod.AddExport("bad", GlobalKind, 1); err != errBadIndex {
+		t.Errorf("out of range global: got %v, want %v", err, errBadIndex)
+	}
+
+	mod = reparse(t, mod)
+	es := mod.section(ExportID).(ExportSection)
+	want := ExportEntry{Field: "run", Kind: FunctionKind, Index: 1}
+	if len(es.Exports) != 3 || es.Exports[2] != want {
+		t.Errorf("got exports %+v, want %+v appended", es.Exports, want)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+
+	// a module without exports gets an export section before its code
+	mod = codeModule(0x0b)
+	if err := mod.AddExport("memory", MemoryKind, 0); err != nil {
+		t.Fatal(err)
+	}
+	if pos := mod.sectionIndex(ExportID); pos != 3 {
+		t.Errorf("export section at %d, want 3", pos)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+}
+
+func TestRenameExport(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.RenameExport("missing", "run"); err != errNoExport {
+		t.Errorf("missing export: got %v, want %v", err, errNoExport)
+	}
+	if err := mod.RenameExport("Main", "memory"); err != errDupExport {
+		t.Errorf("duplicate name: got %v, want %v", err, errDupExport)
+	}
+	if err := mod.RenameExport("Main", "run"); err != nil {
+		t.Fatal(err)
+	}
+
+	mod = reparse(t, mod)
+	if got := mod.ExportsOf(FunctionKind, 1); len(got) != 1 || got[0] != "run" {
+		t.Errorf("function 1 exported as %q, want [run]", got)
+	}
+	if err := mod.RenameExport("Main", "main"); err != errNoExport {
+		t.Errorf("renamed export: got %v, want %v", err, errNoExport)
+	}
+}
+
+func TestSetFuncBody(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := FunctionBody{Code: []byte{Op_return, Op_end}}
+	if err := mod.SetFuncBody(0, ret); err != errBadIndex {
+		t.Errorf("imported function: got %v, want %v", err, errBadIndex)
+	}
+	if err := mod.SetFuncBody(2, ret); err != errBadIndex {
+		t.Errorf("out of range: got %v, want %v", err, errBadIndex)
+	}
+	if err := mod.SetFuncBody(1, ret); err != nil {
+		t.Fatal(err)
+	}
+
+	mod = reparse(t, mod)
+	fb, err := mod.funcBody(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.BodySize != 3 || !bytes.Equal(fb.Code, ret.Code) {
+		t.Errorf("got body of size %d % x, want size 3 % x", fb.BodySize, fb.Code, ret.Code)
+	}
+	if err := mod.TypeCheck(); err != nil {
+		t.Errorf("TypeCheck: %v", err)
+	}
+}
+
+func TestAddImportFunc(t *testing.T) {
+	mod := Module{
+		Header: ModuleHeader{Magic: magicWASM, Version: 1},
+		Sections: []Section{
+			TypeSection{Types: []FuncType{NewFuncType(nil, nil)}},
+			ImportSection{Imports: []ImportEntry{
+				{Module: "env", Field: "a", Kind: FunctionKind, Typ: uint32(0)},
+				{Module: "env", Field: "memory", Kind: MemoryKind, Typ: MemoryType{}},
+			}},
+			FunctionSection{Types: []uint32{0, 0}},
+			TableSection{tables: []TableType{{ElemType: ElemFuncRef, Limits: ResizableLimits{Initial: 2}}}},
+			ExportSection{Exports: []ExportEntry{
+				{Field: "main", Kind: FunctionKind, Index: 1},
+				{Field: "a", Kind: FunctionKind, Index: 0},
+			}},
+			StartSection{Index: 2},
+			ElementSection{elements: []ElemSegment{{Elems: []uint32{0, 2}}}},
+			CodeSection{Bodies: []FunctionBody{
+				{Code: []byte{0x10, 0x00, 0x10, 0x02, 0x0b}}, // call 0; call 2
+				{Code: []byte{0x0b}},
+			}},
+			NameSection{Name: "name", FuncName: []FunctionNames{{1, "main"}, {2, "f"}},
+				// local 0 of function 2 is named "x"
+				Subsections: []NameSubsection{{2, []byte{0x01, 0x02, 0x01, 0x00, 0x01, 'x'}}}},
+		},
+	}
+	orig := mod
+
+	gas := NewFuncType([]ValueType{ValueI64}, nil)
+	idx, err := mod.AddImportFunc("ethereum", "useGas", gas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 1 {
+		t.Errorf("AddImportFunc = %d, want 1", idx)
+	}
+	mod = reparse(t, mod)
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("ValidateMVP: %v", err)
+	}
+	if err := mod.TypeCheck(); err != nil {
+		t.Fatalf("TypeCheck: %v", err)
+	}
+
+	ts := mod.section(TypeID).(TypeSection)
+	if len(ts.Types) != 2 || !ts.Types[1].equal(&gas) {
+		t.Errorf("got types %v, want the useGas signature appended", ts.Types)
+	}
+	imp := mod.section(ImportID).(ImportSection).Imports
+	if len(imp) != 3 || imp[2].Field != "useGas" || imp[2].Typ != uint32(1) {
+		t.Errorf("got imports %+v, want useGas of type 1 appended", imp)
+	}
+	code := mod.section(CodeID).(CodeSection)
+	insns, err := code.Bodies[0].Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insns[0].Index != 0 || insns[1].Index != 3 {
+		t.Errorf("got calls to %d and %d, want 0 and 3", insns[0].Index, insns[1].Index)
+	}
+	exp := mod.section(ExportID).(ExportSection).Exports
+	if exp[0].Index != 2 || exp[1].Index != 0 {
+		t.Errorf("got exports %+v, want main at 2 and a at 0", exp)
+	}
+	if s := mod.section(StartID).(StartSection); s.Index != 3 {
+		t.Errorf("start = %d, want 3", s.Index)
+	}
+	elems := mod.section(ElementID).(ElementSection).elements[0].Elems
+	if elems[0] != 0 || elems[1] != 3 {
+		t.Errorf("got elements %v, want [0 3]", elems)
+	}
+	names := mod.section(UnknownID).(NameSection).FuncName
+	if names[0].Idx != 2 || names[1].Idx != 3 {
+		t.Errorf("got function names %+v, want main at 2 and f at 3", names)
+	}
+	locals := mod.section(UnknownID).(NameSection).Subsections[0].Payload
+	if want := []byte{0x01, 0x03, 0x01, 0x00, 0x01, 'x'}; !bytes.Equal(locals, want) {
+		t.Errorf("got local names % x, want % x", locals, want)
+	}
+
+	// the original module is left untouched
+	if s := orig.section(StartID).(StartSection); s.Index != 2 {
+		t.Errorf("original start = %d, want 2", s.Index)
+	}
+
+	// an existing signature is reused
+	if _, err := mod.AddImportFunc("ethereum", "useGas2", gas); err != nil {
+		t.Fatal(err)
+	}
+	if ts := mod.section(TypeID).(TypeSection); len(ts.Types) != 2 {
+		t.Errorf("got %d types, want 2", len(ts.Types))
+	}
+}
+
+func TestExtractFunction(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, err := mod.ExtractFunction(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext = reparse(t, ext)
+	if err := ext.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	if err := ext.TypeCheck(); err != nil {
+		t.Errorf("TypeCheck: %v", err)
+	}
+	es := ext.section(ExportID).(ExportSection)
+	want := ExportEntry{Field: "Main", Kind: FunctionKind, Index: 1}
+	if len(es.Exports) != 1 || es.Exports[0] != want {
+		t.Errorf("got exports %+v, want %+v", es.Exports, want)
+	}
+	if _, err := mod.ExtractFunction(0); err != errBadIndex {
+		t.Errorf("imported function: got %v, want %v", err, errBadIndex)
+	}
+
+	// f0 calls f2, f1 is dropped along with its type
+	mod = Module{
+		Header: ModuleHeader{Magic: magicWASM, Version: 1},
+		Sections: []Section{
+			TypeSection{Types: []FuncType{
+				{form: ValueFunc, params: []ValueType{ValueI32}},
+				{form: ValueFunc},
+			}},
+			FunctionSection{Types: []uint32{1, 0, 1}},
+			CodeSection{Bodies: []FunctionBody{
+				{Code: []byte{0x10, 0x02, 0x0b}}, // call 2
+				{Code: []byte{0x0b}},
+				{Code: []byte{0x0b}},
+			}},
+		},
+	}
+	if ext, err = mod.ExtractFunction(0); err != nil {
+		t.Fatal(err)
+	}
+	ext = reparse(t, ext)
+	if n := ext.NumFunctions(); n != 2 {
+		t.Errorf("got %d functions, want 2", n)
+	}
+	if ts := ext.section(TypeID).(TypeSection); len(ts.Types) != 1 {
+		t.Errorf("got %d types, want 1", len(ts.Types))
+	}
+	code := ext.section(CodeID).(CodeSection)
+	if !bytes.Equal(code.Bodies[0].Code, []byte{0x10, 0x01, 0x0b}) {
+		t.Errorf("got body %x, want call 1", code.Bodies[0].Code)
+	}
+	if err := ext.TypeCheck(); err != nil {
+		t.Errorf("TypeCheck: %v", err)
+	}
+	if es := ext.section(ExportID).(ExportSection); es.Exports[0].Field != "main" {
+		t.Errorf("got export %q, want \"main\"", es.Exports[0].Field)
+	}
+}
+
+func TestDedupeTypes(t *testing.T) {
+	// types 0 and 2 are equal, f0 is of type 2 and calls through type 2
+	mod := codeModule(0x41, 0x00, 0x11, 0x02, 0x00, 0x0b)
+	mod.Sections[0] = TypeSection{Types: []FuncType{
+		{form: ValueFunc},
+		{form: ValueFunc, params: []ValueType{ValueI32}},
+		{form: ValueFunc},
+	}}
+	mod.Sections[1] = FunctionSection{Types: []uint32{2}}
+	if err := mod.DedupeTypes(); err != nil {
+		t.Fatal(err)
+	}
+	if ts := mod.section(TypeID).(TypeSection); len(ts.Types) != 2 {
+		t.Errorf("got %d types, want 2", len(ts.Types))
+	}
+	if fs := mod.section(FunctionID).(FunctionSection); fs.Types[0] != 0 {
+		t.Errorf("function type = %d, want 0", fs.Types[0])
+	}
+	code := mod.section(CodeID).(CodeSection)
+	if want := []byte{0x41, 0x00, 0x11, 0x00, 0x00, 0x0b}; !bytes.Equal(code.Bodies[0].Code, want) {
+		t.Errorf("got body %x, want %x", code.Bodies[0].Code, want)
+	}
+}
+
+func TestUnusedTypes(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mod.UnusedTypes(); len(got) != 0 {
+		t.Errorf("hello.wasm: got unused types %v, want none", got)
+	}
+
+	// f0 of type 0 calls through type 1, type 2 is unused
+	mod = codeModule(0x41, 0x00, 0x11, 0x01, 0x00, 0x0b)
+	mod.Sections[0] = TypeSection{Types: []FuncType{
+		{form: ValueFunc},
+		{form: ValueFunc, params: []ValueType{ValueI32}},
+		{form: ValueFunc, results: []ValueType{ValueI64}},
+	}}
+	if got := mod.UnusedTypes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("got unused types %v, want [2]", got)
+	}
+}
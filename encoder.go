@@ -0,0 +1,424 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+var (
+	errEncode      = errors.New("wasm: unsupported value for encoding")
+	errSkippedCode = errors.New("wasm: can not encode skipped function bodies")
+)
+
+type encoder struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.buf.WriteByte(b)
+}
+
+func (e *encoder) write(b []byte) {
+	e.buf.Write(b)
+}
+
+func (e *encoder) writeVarU32(v uint32) {
+	uv := varuint32(v)
+	e.buf.Write(uv.bytes())
+}
+
+func (e *encoder) writeVarI64(v int64) {
+	sv := varint64(v)
+	e.buf.Write(sv.bytes())
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeVarU32(uint32(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) writeValueType(vt ValueType) {
+	e.writeVarI64(int64(vt))
+}
+
+// WriteTo encodes the module to w.
+func (m Module) WriteTo(w io.Writer) (int64, error) {
+	var e encoder
+	e.write(m.Header.Bytes())
+	for _, s := range m.Sections {
+		e.writeSection(s)
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	return e.buf.WriteTo(w)
+}
+
+// Bytes returns the encoded module.
+func (m Module) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile encodes the module to the file name, creating or truncating it.
+func (m Module) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Canonical returns the canonical encoding of the module: the known sections
+// in spec order, equal types merged, function bodies re-encoded with minimal
+// LEB128 and custom sections dropped. Decoding and encoding the result again
+// yields the same bytes.
+func (m Module) Canonical() ([]byte, error) {
+	c := Module{Header: m.Header}
+	for _, s := range m.Sections {
+		if s.ID() != UnknownID {
+			c.Sections = append(c.Sections, s)
+		}
+	}
+	sort.SliceStable(c.Sections, func(i, j int) bool {
+		return sectionOrder(c.Sections[i].ID()) < sectionOrder(c.Sections[j].ID())
+	})
+	if err := c.DedupeTypes(); err != nil {
+		return nil, err
+	}
+	if pos := c.sectionIndex(CodeID); pos >= 0 {
+		cs := c.Sections[pos].(CodeSection)
+		if cs.Skipped != 0 {
+			return nil, errSkippedCode
+		}
+		bodies, err := rewriteCode(cs.Bodies, func(*Instruction) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		cs.Bodies = bodies
+		cs.size = 0
+		c.Sections[pos] = cs
+	}
+	return c.Bytes()
+}
+
+func (e *encoder) writeSection(s Section) {
+	if e.err != nil {
+		return
+	}
+
+	var body encoder
+	body.writeSectionBody(s)
+	if body.err != nil {
+		e.err = body.err
+		return
+	}
+	e.writeByte(byte(s.ID()))
+	e.writeVarU32(uint32(body.buf.Len()))
+	e.write(body.buf.Bytes())
+}
+
+// sectionSize returns the size of the encoded body of s, or zero if s cannot
+// be encoded.
+func sectionSize(s Section) int {
+	var e encoder
+	e.writeSectionBody(s)
+	if e.err != nil {
+		return 0
+	}
+	return e.buf.Len()
+}
+
+func (e *encoder) writeSectionBody(sec Section) {
+	switch s := sec.(type) {
+	case NameSection:
+		e.writeString(s.Name)
+		if s.Name == "name" {
+			e.writeNameSection(&s)
+		} else {
+			e.write(s.Payload)
+		}
+
+	case TypeSection:
+		e.writeVarU32(uint32(len(s.Types)))
+		for i := range s.Types {
+			e.writeFuncType(&s.Types[i])
+		}
+
+	case ImportSection:
+		e.writeVarU32(uint32(len(s.Imports)))
+		for i := range s.Imports {
+			e.writeImportEntry(&s.Imports[i])
+		}
+
+	case FunctionSection:
+		e.writeVarU32(uint32(len(s.Types)))
+		for _, idx := range s.Types {
+			e.writeVarU32(idx)
+		}
+
+	case TableSection:
+		e.writeVarU32(uint32(len(s.tables)))
+		for i := range s.tables {
+			e.writeTableType(&s.tables[i])
+		}
+
+	case MemorySection:
+		e.writeVarU32(uint32(len(s.memories)))
+		for i := range s.memories {
+			e.writeResizableLimits(&s.memories[i].Limits)
+		}
+
+	case GlobalSection:
+		e.writeVarU32(uint32(len(s.globals)))
+		for i := range s.globals {
+			e.writeGlobalType(&s.globals[i].Type)
+			e.writeInitExpr(&s.globals[i].Init)
+		}
+
+	case ExportSection:
+		e.writeVarU32(uint32(len(s.Exports)))
+		for _, ee := range s.Exports {
+			e.writeString(ee.Field)
+			e.writeByte(byte(ee.Kind))
+			e.writeVarU32(ee.Index)
+		}
+
+	case StartSection:
+		e.writeVarU32(s.Index)
+
+	case ElementSection:
+		e.writeVarU32(uint32(len(s.elements)))
+		for i := range s.elements {
+			e.writeElemSegment(&s.elements[i])
+		}
+
+	case CodeSection:
+		if s.Skipped != 0 {
+			e.err = errSkippedCode
+			return
+		}
+		e.writeVarU32(uint32(len(s.Bodies)))
+		for i := range s.Bodies {
+			e.writeFunctionBody(&s.Bodies[i])
+		}
+
+	case DataSection:
+		e.writeVarU32(uint32(len(s.segments)))
+		for i := range s.segments {
+			ds := &s.segments[i]
+			e.writeVarU32(ds.Index)
+			e.writeInitExpr(&ds.Offset)
+			e.writeVarU32(uint32(len(ds.Data)))
+			e.write(ds.Data)
+		}
+
+	case TagSection:
+		e.writeVarU32(uint32(len(s.Tags)))
+		for _, tt := range s.Tags {
+			e.writeByte(tt.Attribute)
+			e.writeVarU32(tt.Type)
+		}
+
+	case DataCountSection:
+		e.writeVarU32(s.Count)
+
+	default:
+		e.err = fmt.Errorf("wasm: can not encode section %T", sec)
+	}
+}
+
+func (e *encoder) writeNameSection(s *NameSection) {
+	var sub encoder
+	if s.ModName != "" {
+		sub.writeString(s.ModName)
+		e.writeByte(0)
+		e.writeVarU32(uint32(sub.buf.Len()))
+		e.write(sub.buf.Bytes())
+	}
+	if len(s.FuncName) > 0 {
+		sub.buf.Reset()
+		sub.writeVarU32(uint32(len(s.FuncName)))
+		for _, fn := range s.FuncName {
+			sub.writeVarU32(fn.Idx)
+			sub.writeString(fn.Name)
+		}
+		e.writeByte(1)
+		e.writeVarU32(uint32(sub.buf.Len()))
+		e.write(sub.buf.Bytes())
+	}
+	for _, ss := range s.Subsections {
+		e.writeByte(ss.ID)
+		e.writeVarU32(uint32(len(ss.Payload)))
+		e.write(ss.Payload)
+	}
+}
+
+func (e *encoder) writeFuncType(ft *FuncType) {
+	e.writeValueType(ft.form)
+	if ft.gc != nil {
+		e.write(ft.gc)
+		return
+	}
+	e.writeVarU32(uint32(len(ft.params)))
+	for _, vt := range ft.params {
+		e.writeValueType(vt)
+	}
+	e.writeVarU32(uint32(len(ft.results)))
+	for _, vt := range ft.results {
+		e.writeValueType(vt)
+	}
+}
+
+func (e *encoder) writeImportEntry(ie *ImportEntry) {
+	e.writeString(ie.Module)
+	e.writeString(ie.Field)
+	e.writeByte(byte(ie.Kind))
+	switch typ := ie.Typ.(type) {
+	case uint32:
+		e.writeVarU32(typ)
+	case TableType:
+		e.writeTableType(&typ)
+	case MemoryType:
+		e.writeResizableLimits(&typ.Limits)
+	case GlobalType:
+		e.writeGlobalType(&typ)
+	default:
+		e.err = errEncode
+	}
+}
+
+func (e *encoder) writeTableType(tt *TableType) {
+	e.writeVarI64(int64(tt.ElemType))
+	e.writeResizableLimits(&tt.Limits)
+}
+
+func (e *encoder) writeResizableLimits(tl *ResizableLimits) {
+	e.writeVarU32(tl.Flags)
+	e.writeLimit(tl.Memory64(), tl.Initial)
+	if (tl.Flags & 0x1) != 0 {
+		e.writeLimit(tl.Memory64(), tl.Maximum)
+	}
+}
+
+// writeLimit writes a limit, a varuint64 for a 64-bit memory.
+func (e *encoder) writeLimit(is64 bool, v uint64) {
+	if !is64 {
+		if v > math.MaxUint32 {
+			e.err = fmt.Errorf("%w: limit %d of a 32-bit memory or table", errEncode, v)
+			return
+		}
+		e.writeVarU32(uint32(v))
+		return
+	}
+	for v >= 0x80 {
+		e.writeByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	e.writeByte(byte(v))
+}
+
+func (e *encoder) writeElemSegment(es *ElemSegment) {
+	flags := es.Flags
+	if flags == 0 && es.Index != 0 {
+		// an MVP segment of another table
+		flags = 0x2
+	}
+	e.writeVarU32(flags)
+	if flags&0x3 == 0x2 {
+		e.writeVarU32(es.Index)
+	}
+	if flags&0x1 == 0 {
+		e.writeInitExpr(&es.Offset)
+	}
+	if flags&0x3 != 0 {
+		if flags&0x4 == 0 {
+			e.writeByte(0) // elemkind funcref
+		} else if es.Type == 0 {
+			e.writeVarI64(int64(ElemFuncRef))
+		} else {
+			e.writeVarI64(int64(es.Type))
+		}
+	}
+	if flags&0x4 != 0 {
+		e.writeVarU32(uint32(len(es.Exprs)))
+		for i := range es.Exprs {
+			e.writeInitExpr(&es.Exprs[i])
+		}
+		return
+	}
+	e.writeVarU32(uint32(len(es.Elems)))
+	for _, idx := range es.Elems {
+		e.writeVarU32(idx)
+	}
+}
+
+func (e *encoder) writeGlobalType(gt *GlobalType) {
+	e.writeValueType(gt.ContentType)
+	e.writeByte(byte(gt.Mutability))
+}
+
+func (e *encoder) writeInitExpr(ie *InitExpr) {
+	var buf [8]byte
+	switch ie.Op {
+	case Op_unreachable, Op_i32_const:
+		e.writeByte(byte(Op_i32_const))
+		e.writeVarI64(int64(int32(ie.Value)))
+	case Op_i64_const:
+		e.writeByte(Op_i64_const)
+		e.writeVarI64(ie.Value)
+	case Op_f32_const:
+		e.writeByte(Op_f32_const)
+		order.PutUint32(buf[:4], uint32(ie.Value))
+		e.write(buf[:4])
+	case Op_f64_const:
+		e.writeByte(Op_f64_const)
+		order.PutUint64(buf[:], uint64(ie.Value))
+		e.write(buf[:])
+	case Op_get_global:
+		e.writeByte(Op_get_global)
+		e.writeVarU32(uint32(ie.Value))
+	case Op_simd_prefix:
+		e.writeByte(byte(Op_simd_prefix))
+		e.writeVarU32(Simd_v128_const)
+		e.write(ie.V128[:])
+	case Op_ref_null:
+		e.writeByte(byte(Op_ref_null))
+		e.writeVarI64(ie.Value)
+	case Op_ref_func:
+		e.writeByte(Op_ref_func)
+		e.writeVarU32(uint32(ie.Value))
+	default:
+		e.err = errEncode
+	}
+	e.writeByte(Op_end)
+}
+
+func (e *encoder) writeFunctionBody(fb *FunctionBody) {
+	var body encoder
+	body.writeVarU32(uint32(len(fb.Locals)))
+	for _, le := range fb.Locals {
+		body.writeVarU32(le.Count)
+		body.writeValueType(le.Type)
+	}
+	body.write(fb.Code)
+	e.writeVarU32(uint32(body.buf.Len()))
+	e.write(body.buf.Bytes())
+}
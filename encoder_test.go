@@ -0,0 +1,298 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := mod.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteTo:\ngot  %x\nwant %x", buf.Bytes(), want)
+	}
+}
+
+func TestTableSectionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		section []byte
+		tables  []TableType
+	}{
+		{"none", []byte{0x04, 0x01, 0x00}, nil},
+		{"funcref", []byte{0x04, 0x04, 0x01, 0x70, 0x00, 0x01},
+			[]TableType{{ElemFuncRef, ResizableLimits{Initial: 1}}}},
+		{"many", []byte{0x04, 0x0b, 0x03, 0x70, 0x00, 0x01, 0x70, 0x01, 0x01, 0x02, 0x6f, 0x00, 0x00},
+			[]TableType{
+				{ElemFuncRef, ResizableLimits{Initial: 1}},
+				{ElemFuncRef, ResizableLimits{Flags: 1, Initial: 1, Maximum: 2}},
+				{ElemExternRef, ResizableLimits{}},
+			}},
+	}
+	for _, tt := range tests {
+		b := append(append([]byte{}, wasmHeader...), tt.section...)
+		mod, err := Parse(b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		ts := mod.section(TableID).(TableSection)
+		if len(ts.tables) != len(tt.tables) {
+			t.Fatalf("%s: got %d tables, want %d", tt.name, len(ts.tables), len(tt.tables))
+		}
+		for i := range tt.tables {
+			if ts.tables[i] != tt.tables[i] {
+				t.Errorf("%s: table %d: got %+v, want %+v", tt.name, i, ts.tables[i], tt.tables[i])
+			}
+		}
+		got, err := mod.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got[len(wasmHeader):], tt.section) {
+			t.Errorf("%s: got section % x, want % x", tt.name, got[len(wasmHeader):], tt.section)
+		}
+	}
+}
+
+func TestSetModuleName(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"hello", "world"} {
+		mod.SetModuleName(name)
+		b, err := mod.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mod, err = Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mod.Sections) != 10 {
+			t.Fatalf("got %d sections, want 10", len(mod.Sections))
+		}
+		ns, ok := mod.Sections[9].(NameSection)
+		if !ok || ns.Name != "name" {
+			t.Fatalf("last section is %T, want the name section", mod.Sections[9])
+		}
+		if ns.ModName != name {
+			t.Errorf("module name = %q, want %q", ns.ModName, name)
+		}
+	}
+}
+
+func TestNameSubsections(t *testing.T) {
+	funcs := []byte{0x01, 0x04, 0x01, 0x00, 0x01, 'f'}
+	locals := []byte{0x02, 0x06, 0x01, 0x00, 0x01, 0x00, 0x01, 'x'}
+	unknown := []byte{0x07, 0x02, 0xaa, 0xbb}
+	payload := append([]byte{0x04, 'n', 'a', 'm', 'e'}, funcs...)
+	payload = append(append(payload, locals...), unknown...)
+	b := append(append([]byte{}, wasmHeader...), 0x00, byte(len(payload)))
+	mod, err := Parse(append(b, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := mod.Sections[0].(NameSection)
+	want := []NameSubsection{{2, locals[2:]}, {7, unknown[2:]}}
+	if len(ns.Subsections) != len(want) {
+		t.Fatalf("got %d subsections, want %d", len(ns.Subsections), len(want))
+	}
+	for i, ss := range ns.Subsections {
+		if ss.ID != want[i].ID || !bytes.Equal(ss.Payload, want[i].Payload) {
+			t.Errorf("subsection %d = %d % x, want %d % x",
+				i, ss.ID, ss.Payload, want[i].ID, want[i].Payload)
+		}
+	}
+
+	mod.SetModuleName("m")
+	got, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload = append([]byte{0x04, 'n', 'a', 'm', 'e', 0x00, 0x02, 0x01, 'm'}, funcs...)
+	payload = append(append(payload, locals...), unknown...)
+	b = append(append([]byte{}, wasmHeader...), 0x00, byte(len(payload)))
+	if b = append(b, payload...); !bytes.Equal(got, b) {
+		t.Errorf("got  % x\nwant % x", got, b)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "hello.wasm")
+	if err := mod.WriteFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("WriteFile:\ngot  %x\nwant %x", got, want)
+	}
+
+	if err := mod.WriteFile(filepath.Join(dir, "missing", "hello.wasm")); err == nil {
+		t.Errorf("WriteFile into a missing directory succeeded")
+	}
+}
+
+// checkSectionSizes compares the Size of the sections of mod with the
+// sizes declared by their encoding buf.
+func checkSectionSizes(t *testing.T, name string, mod Module, buf []byte) {
+	t.Helper()
+	r := bytes.NewReader(buf[8:])
+	for i, s := range mod.Sections {
+		id, _ := r.ReadByte()
+		sz, _, err := uvarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if SectionID(id) != s.ID() || s.Size() != int(sz) {
+			t.Errorf("%s: section[%d]: %s Size() = %d, want %s size %d",
+				name, i, s.ID(), s.Size(), SectionID(id), sz)
+		}
+		r.Seek(int64(sz), io.SeekCurrent)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%s: %d bytes left after the last section", name, r.Len())
+	}
+}
+
+func TestSectionSize(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range files {
+		buf, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mod, err := Parse(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", fname, err)
+		}
+		checkSectionSizes(t, fname, mod, buf)
+	}
+
+	// a modified module reports the sizes of its encoding
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.SetModuleName("hello")
+	if err := mod.AddExport("hello", FunctionKind, 0); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSectionSizes(t, "modified hello.wasm", mod, buf)
+
+	// a decoded section reports its size as read, not as re-encoded
+	types := []byte{0x81, 0x00, 0x60, 0x00, 0x00}
+	b := append(append([]byte{}, wasmHeader...), 0x01, byte(len(types)))
+	if mod, err = Parse(append(b, types...)); err != nil {
+		t.Fatal(err)
+	}
+	if got := mod.Sections[0].Size(); got != len(types) {
+		t.Errorf("decoded type section Size() = %d, want %d", got, len(types))
+	}
+
+	// and so does a name section, until it is modified
+	name := []byte{0x04, 'n', 'a', 'm', 'e', 0x01, 0x05, 0x81, 0x00, 0x00, 0x01, 'f'}
+	b = append(append([]byte{}, wasmHeader...), 0x00, byte(len(name)))
+	if mod, err = Parse(append(b, name...)); err != nil {
+		t.Fatal(err)
+	}
+	if got := mod.Sections[0].Size(); got != len(name) {
+		t.Errorf("decoded name section Size() = %d, want %d", got, len(name))
+	}
+	mod.SetModuleName("m")
+	if got, want := mod.Sections[0].Size(), sectionSize(mod.Sections[0]); got != want {
+		t.Errorf("renamed name section Size() = %d, want %d", got, want)
+	}
+
+	mem := MemorySection{memories: []MemoryType{{Limits: ResizableLimits{Initial: 1 << 32}}}}
+	if got := mem.Size(); got != 0 {
+		t.Errorf("Size() of a memory which cannot be encoded = %d, want 0", got)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a custom section and a duplicated type
+	mod.SetModuleName("hello")
+	ts := mod.section(TypeID).(TypeSection)
+	mod.Sections[0] = TypeSection{Types: append(append([]FuncType{}, ts.Types...), ts.Types[0])}
+
+	want, err := mod.Canonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	canon, err := Parse(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canon.Sections) != 9 {
+		t.Errorf("got %d sections, want 9", len(canon.Sections))
+	}
+	if ts := canon.section(TypeID).(TypeSection); len(ts.Types) != 2 {
+		t.Errorf("got %d types, want 2", len(ts.Types))
+	}
+	if err := canon.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	raw, _ := ioutil.ReadFile("testdata/hello.wasm")
+	if len(want) >= len(raw) {
+		t.Errorf("canonical encoding is %d bytes, want less than the padded %d", len(want), len(raw))
+	}
+
+	got, err := canon.Canonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Canonical is not idempotent:\ngot  %x\nwant %x", got, want)
+	}
+}
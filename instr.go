@@ -0,0 +1,327 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"io"
+)
+
+// MemArg is the immediate of load and store instructions.
+type MemArg struct {
+	Align  uint32 // alignment as a power of two exponent
+	Offset uint32 // constant offset added to the address operand
+}
+
+// Instruction is a decoded instruction of a function body.
+type Instruction struct {
+	Op     Opcode
+	Offset int // offset of the opcode within FunctionBody.Code
+
+	Block   BlockType // signature of block, loop and if
+	Index   uint32    // label, function, type, local, global or memory index
+	Table   uint32    // table index of call_indirect
+	Targets []uint32  // labels of br_table, the default label is in Index
+	Value   int64     // value of i32/i64.const, raw bits of f32/f64.const
+	Mem     MemArg    // memory immediate of load and store
+	Sub     uint32    // opcode following a prefix byte
+	V128    [16]byte  // value of v128.const
+}
+
+// MemoryAccess is a load or store instruction of a function body.
+type MemoryAccess struct {
+	Op     Opcode
+	Mem    MemArg // alignment and static offset of the access
+	Offset int    // offset of the instruction within FunctionBody.Code
+}
+
+// MemoryAccesses returns the loads and stores of the function funcIdx,
+// which must be defined by the module.
+func (m Module) MemoryAccesses(funcIdx uint32) ([]MemoryAccess, error) {
+	fb, err := m.funcBody(funcIdx)
+	if err != nil {
+		return nil, err
+	}
+	insns, err := fb.decode(funcIdx)
+	if err != nil {
+		return nil, err
+	}
+	var accesses []MemoryAccess
+	for _, ins := range insns {
+		if opImmediate(ins.Op) == immMemArg {
+			accesses = append(accesses, MemoryAccess{Op: ins.Op, Mem: ins.Mem, Offset: ins.Offset})
+		}
+	}
+	return accesses, nil
+}
+
+// OpcodeHistogram counts the opcodes of all function bodies. Prefixed
+// instructions are counted by their prefix byte. It fails if the code was
+// skipped or does not decode.
+func (m Module) OpcodeHistogram() (map[Opcode]int, error) {
+	code, _ := m.section(CodeID).(CodeSection)
+	if code.Skipped != 0 {
+		return nil, errSkippedCode
+	}
+	hist := make(map[Opcode]int)
+	for i, fb := range code.Bodies {
+		insns, err := fb.decode(m.AbsoluteFuncIndex(i))
+		if err != nil {
+			return nil, err
+		}
+		for _, ins := range insns {
+			hist[ins.Op]++
+		}
+	}
+	return hist, nil
+}
+
+// Instructions decodes the code of the function body.
+func (fb FunctionBody) Instructions() ([]Instruction, error) {
+	insns, _, err := decodeInstructions(fb.Code)
+	return insns, err
+}
+
+// decode decodes the code of the body of function idx, a failure is reported
+// as a CodeError at the offset of the instruction which does not decode.
+func (fb FunctionBody) decode(idx uint32) ([]Instruction, error) {
+	insns, off, err := decodeInstructions(fb.Code)
+	if err != nil {
+		return insns, &CodeError{Func: idx, Offset: off, Err: err}
+	}
+	return insns, nil
+}
+
+// decodeInstructions decodes code, on failure it returns the instructions
+// decoded so far and the offset of the one which does not decode.
+func decodeInstructions(code []byte) ([]Instruction, int, error) {
+	var d decoder
+	return d.readCode(code)
+}
+
+// readCode decodes the instructions of code, see decodeInstructions.
+func (d *decoder) readCode(code []byte) ([]Instruction, int, error) {
+	r := bytes.NewReader(code)
+	var insns []Instruction
+	for r.Len() > 0 {
+		ins := Instruction{Offset: len(code) - r.Len()}
+		d.readInstruction(r, &ins)
+		if d.err != nil {
+			if d.err == io.EOF {
+				d.err = io.ErrUnexpectedEOF
+			}
+			return insns, ins.Offset, d.err
+		}
+		insns = append(insns, ins)
+	}
+	return insns, 0, nil
+}
+
+// immKind classifies the immediate operands of an opcode.
+type immKind int
+
+const (
+	immInvalid      immKind = iota
+	immNone                 // no immediates
+	immBlock                // block type
+	immIndex                // a single varuint32 index: label, function, variable or tag
+	immBrTable              // label vector and default label
+	immCallIndirect         // type index and table index
+	immMemArg               // alignment and offset
+	immMemory               // memory index, a reserved zero byte in MVP
+	immI32                  // varint32
+	immI64                  // varint64
+	immF32                  // 4 bytes
+	immF64                  // 8 bytes
+	immSimd                 // SIMD opcode and its immediates
+)
+
+func opImmediate(op Opcode) immKind {
+	switch {
+	case op == Op_block || op == Op_loop || op == Op_if || op == Op_try:
+		return immBlock
+	case op == Op_br || op == Op_br_if || op == Op_call ||
+		op >= Op_get_local && op <= Op_set_global,
+		op == Op_catch || op == Op_throw || op == Op_rethrow || op == Op_delegate:
+		return immIndex
+	case op == Op_br_table:
+		return immBrTable
+	case op == Op_call_indirect:
+		return immCallIndirect
+	case op >= Op_i32_load && op <= Op_i64_store32:
+		return immMemArg
+	case op == Op_current_memory || op == Op_grow_memory:
+		return immMemory
+	case op == Op_i32_const:
+		return immI32
+	case op == Op_i64_const:
+		return immI64
+	case op == Op_f32_const:
+		return immF32
+	case op == Op_f64_const:
+		return immF64
+	case op == Op_simd_prefix:
+		return immSimd
+	case op <= Op_nop, op == Op_else, op == Op_end, op == Op_return, op == Op_catch_all,
+		op == Op_drop, op == Op_select,
+		op >= Op_i32_eqz && op <= Op_f64_reinterpret_i64:
+		return immNone
+	}
+	return immInvalid
+}
+
+func (d *decoder) readInstruction(r *bytes.Reader, ins *Instruction) {
+	if d.err != nil {
+		return
+	}
+
+	var buf [8]byte
+	d.read(r, buf[:1])
+	if d.err != nil {
+		return
+	}
+	ins.Op = Opcode(buf[0])
+
+	switch opImmediate(ins.Op) {
+	case immBlock:
+		var v int64
+		d.readVarI64(r, &v)
+		ins.Block = BlockType(v)
+
+	case immIndex:
+		d.readVarU32(r, &ins.Index)
+
+	case immBrTable:
+		var n uint32
+		d.readVarU32(r, &n)
+		if d.err != nil {
+			return
+		}
+		// every label takes at least a byte, the default label included
+		if int64(n) >= int64(r.Len()) {
+			d.err = errBrTable
+			return
+		}
+		ins.Targets = make([]uint32, int(n))
+		for i := range ins.Targets {
+			d.readVarU32(r, &ins.Targets[i])
+		}
+		d.readVarU32(r, &ins.Index)
+
+	case immCallIndirect:
+		// the table index is a reserved zero byte in the MVP and a
+		// varuint32 with reference-types, both decode the same.
+		d.readVarU32(r, &ins.Index)
+		d.readVarU32(r, &ins.Table)
+
+	case immMemArg:
+		d.readVarU32(r, &ins.Mem.Align)
+		d.readVarU32(r, &ins.Mem.Offset)
+
+	case immMemory:
+		// a reserved zero byte in MVP, checked by validation
+		d.readVarU32(r, &ins.Index)
+
+	case immI32:
+		var v int32
+		d.readVarI32(r, &v)
+		ins.Value = int64(v)
+
+	case immI64:
+		d.readVarI64(r, &ins.Value)
+
+	case immF32:
+		d.read(r, buf[:4])
+		ins.Value = int64(order.Uint32(buf[:4]))
+
+	case immF64:
+		d.read(r, buf[:8])
+		ins.Value = int64(order.Uint64(buf[:8]))
+
+	case immSimd:
+		// only v128.const is supported
+		d.readVarU32(r, &ins.Sub)
+		if d.err == nil && ins.Sub != Simd_v128_const {
+			d.err = errInvOp
+		}
+		d.read(r, ins.V128[:])
+
+	case immNone:
+
+	default:
+		d.err = errInvOp
+	}
+}
+
+// EncodeInstructions encodes insns into function body code, the Offset of
+// the instructions is ignored.
+func EncodeInstructions(insns []Instruction) ([]byte, error) {
+	var e encoder
+	for i := range insns {
+		e.writeInstruction(&insns[i])
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buf.Bytes(), nil
+}
+
+func (e *encoder) writeInstruction(ins *Instruction) {
+	if e.err != nil {
+		return
+	}
+
+	var buf [8]byte
+	e.writeByte(byte(ins.Op))
+	switch opImmediate(ins.Op) {
+	case immBlock:
+		e.writeVarI64(int64(ins.Block))
+
+	case immIndex, immMemory:
+		e.writeVarU32(ins.Index)
+
+	case immBrTable:
+		e.writeVarU32(uint32(len(ins.Targets)))
+		for _, l := range ins.Targets {
+			e.writeVarU32(l)
+		}
+		e.writeVarU32(ins.Index)
+
+	case immCallIndirect:
+		e.writeVarU32(ins.Index)
+		e.writeVarU32(ins.Table)
+
+	case immMemArg:
+		e.writeVarU32(ins.Mem.Align)
+		e.writeVarU32(ins.Mem.Offset)
+
+	case immI32:
+		e.writeVarI64(int64(int32(ins.Value)))
+
+	case immI64:
+		e.writeVarI64(ins.Value)
+
+	case immF32:
+		order.PutUint32(buf[:4], uint32(ins.Value))
+		e.write(buf[:4])
+
+	case immF64:
+		order.PutUint64(buf[:], uint64(ins.Value))
+		e.write(buf[:])
+
+	case immSimd:
+		if ins.Sub != Simd_v128_const {
+			e.err = errInvOp
+			return
+		}
+		e.writeVarU32(ins.Sub)
+		e.write(ins.V128[:])
+
+	case immNone:
+
+	default:
+		e.err = errInvOp
+	}
+}
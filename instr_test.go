@@ -0,0 +1,240 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCallIndirect(t *testing.T) {
+	tests := []struct {
+		code  []byte
+		typ   uint32
+		table uint32
+	}{
+		// i32.const 0; call_indirect (type 0); end
+		{[]byte{0x41, 0x00, 0x11, 0x00, 0x00, 0x0b}, 0, 0},
+		// reference-types: call_indirect 1 (type 2)
+		{[]byte{0x41, 0x00, 0x11, 0x02, 0x01, 0x0b}, 2, 1},
+	}
+
+	for _, tt := range tests {
+		insns, err := FunctionBody{Code: tt.code}.Instructions()
+		if err != nil {
+			t.Fatalf("Instructions(%x): %v", tt.code, err)
+		}
+		if len(insns) != 3 {
+			t.Fatalf("Instructions(%x): got %d instructions, want 3", tt.code, len(insns))
+		}
+		ins := insns[1]
+		if ins.Op != Op_call_indirect || ins.Offset != 2 {
+			t.Errorf("got op 0x%x at %d, want call_indirect at 2", byte(ins.Op), ins.Offset)
+		}
+		if ins.Index != tt.typ || ins.Table != tt.table {
+			t.Errorf("call_indirect type=%d table=%d, want type=%d table=%d",
+				ins.Index, ins.Table, tt.typ, tt.table)
+		}
+	}
+}
+
+func TestInstructions(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := mod.section(CodeID).(CodeSection)
+	insns, err := code.Bodies[0].Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := []Opcode{Op_i32_const, Op_i32_const, Op_call, Op_end}
+	if len(insns) != len(ops) {
+		t.Fatalf("got %d instructions, want %d", len(insns), len(ops))
+	}
+	for i, op := range ops {
+		if insns[i].Op != op {
+			t.Errorf("insn[%d]: got op 0x%x, want 0x%x", i, byte(insns[i].Op), byte(op))
+		}
+	}
+	if insns[0].Value != 1024 || insns[1].Value != 8 || insns[2].Index != 0 {
+		t.Errorf("unexpected immediates: %+v", insns)
+	}
+}
+
+func TestEncodeInstructions(t *testing.T) {
+	code := []byte{
+		0x02, 0x40, // block
+		0x03, 0x7f, // loop (result i32)
+		0x41, 0x2a, // i32.const 42
+		0x0d, 0x00, // br_if 0
+		0x41, 0x80, 0x08, // i32.const 1024
+		0x0e, 0x02, 0x00, 0x01, 0x01, // br_table 0 1 1
+		0x0b,       // end
+		0x04, 0x40, // if
+		0x42, 0xff, 0x00, // i64.const 127
+		0x1a,                         // drop
+		0x05,                         // else
+		0x43, 0x00, 0x00, 0x80, 0x3f, // f32.const 1.0
+		0x1a,                               // drop
+		0x0b,                               // end
+		0x0b,                               // end
+		0x44, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // f64.const 1.0
+		0x1a,       // drop
+		0x41, 0x00, // i32.const 0
+		0x28, 0x02, 0x10, // i32.load offset=16
+		0x41, 0x00, // i32.const 0
+		0x11, 0x01, 0x00, // call_indirect (type 1)
+		0x3f, 0x00, // current_memory
+		0x21, 0x03, // set_local 3
+		0x10, 0x05, // call 5
+		0xfd, 0x0c, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, // v128.const
+		0x1a, // drop
+		0x0f, // return
+		0x0b, // end
+	}
+	insns, err := FunctionBody{Code: code}.Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EncodeInstructions(insns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, code) {
+		t.Errorf("EncodeInstructions:\ngot  %x\nwant %x", got, code)
+	}
+
+	if _, err := EncodeInstructions([]Instruction{{Op: 0x12}}); err != errInvOp {
+		t.Errorf("invalid opcode: got %v, want %v", err, errInvOp)
+	}
+}
+
+func TestExceptionInstructions(t *testing.T) {
+	// a tag of type (i32) and a function throwing and catching it:
+	// try/throw/catch/catch_all, try/delegate and try/catch_all/rethrow
+	b, err := ioutil.ReadFile("testdata/exception.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := mod.section(TagID).(TagSection); !ok || len(ts.Tags) != 1 || ts.Tags[0].Type != 0 {
+		t.Fatalf("got tags %+v", mod.section(TagID))
+	}
+	fb, err := mod.funcBody(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insns, err := fb.Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"try", "i32.const 1", "throw 0", "catch 0", "drop", "catch_all", "end",
+		"try", "nop", "delegate 0",
+		"try", "catch_all", "rethrow 0", "end",
+		"end",
+	}
+	if len(insns) != len(want) {
+		t.Fatalf("got %d instructions, want %d: %v", len(insns), len(want), insns)
+	}
+	for i, ins := range insns {
+		if s := ins.String(); s != want[i] {
+			t.Errorf("instruction %d: got %q, want %q", i, s, want[i])
+		}
+	}
+	got, err := EncodeInstructions(insns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, fb.Code) {
+		t.Errorf("EncodeInstructions:\ngot  %x\nwant %x", got, fb.Code)
+	}
+
+	dis, err := mod.DisassembleFunc(0, DisassembleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dis, "try\n  i32.const 1\n  throw 0\ncatch 0\n  drop\ncatch_all\nend\n") {
+		t.Errorf("DisassembleFunc:\n%s", dis)
+	}
+}
+
+func TestOpcodeHistogram(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err := mod.OpcodeHistogram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for op, want := range map[Opcode]int{Op_i32_const: 2, Op_call: 1, Op_end: 1, Op_drop: 0} {
+		if hist[op] != want {
+			t.Errorf("%s: got %d, want %d", op, hist[op], want)
+		}
+	}
+
+	bad := codeModule(0x41) // truncated i32.const
+	if _, err := bad.OpcodeHistogram(); err == nil {
+		t.Error("truncated code: got no error")
+	}
+}
+
+func TestMemoryAccesses(t *testing.T) {
+	mod := codeModule(
+		0x41, 0x00, // i32.const 0
+		0x41, 0x00, // i32.const 0
+		0x28, 0x02, 0x10, // i32.load offset=16
+		0x36, 0x01, 0x80, 0x02, // i32.store align=2 offset=256
+		0x0b,
+	)
+	got, err := mod.MemoryAccesses(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MemoryAccess{
+		{Op: Op_i32_load, Mem: MemArg{Align: 2, Offset: 16}, Offset: 4},
+		{Op: Op_i32_store, Mem: MemArg{Align: 1, Offset: 256}, Offset: 7},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("access %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := mod.MemoryAccesses(1); err != errBadIndex {
+		t.Errorf("missing function: got %v, want %v", err, errBadIndex)
+	}
+}
+
+func TestBrTable(t *testing.T) {
+	code := []byte{
+		0x0e, 0x03, 0x00, 0x01, 0x02, 0x00, // br_table 0 1 2 0
+		0x0b,
+	}
+	insns, err := FunctionBody{Code: code}.Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins := insns[0]
+	if len(ins.Targets) != 3 || ins.Targets[2] != 2 || ins.Index != 0 {
+		t.Errorf("got targets %v default %d, want [0 1 2] default 0", ins.Targets, ins.Index)
+	}
+
+	// a label count of 2^32-1 with a few bytes of code
+	code = []byte{0x0e, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x00, 0x00, 0x0b}
+	if _, err := (FunctionBody{Code: code}).Instructions(); err != errBrTable {
+		t.Errorf("bogus label count: got %v, want %v", err, errBrTable)
+	}
+}
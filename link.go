@@ -0,0 +1,184 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errLinkSig         = errors.New("wasm: link: import and export signatures differ")
+	errLinkUnsupported = errors.New("wasm: link: the second module may only import and define functions")
+)
+
+// Link merges the functions of b into a. The function imports of each module
+// matching by field name a function defined and exported by the other are
+// resolved to it, their signatures must be equal, the other imports are kept.
+// The functions of a come first, followed by the functions of b, and all
+// references to them are renumbered.
+//
+// b may only hold types, function imports, functions and exports, its custom
+// sections are dropped.
+func Link(a, b Module) (Module, error) {
+	for _, s := range b.Sections {
+		switch s := s.(type) {
+		case TypeSection, FunctionSection, ExportSection, CodeSection, NameSection:
+		case ImportSection:
+			for _, imp := range s.Imports {
+				if imp.Kind != FunctionKind {
+					return Module{}, errLinkUnsupported
+				}
+			}
+		default:
+			return Module{}, errLinkUnsupported
+		}
+	}
+
+	ca, cb := a.context(), b.context()
+	resA, err := resolveImports(a, ca, b, cb)
+	if err != nil {
+		return Module{}, err
+	}
+	resB, err := resolveImports(b, cb, a, ca)
+	if err != nil {
+		return Module{}, err
+	}
+
+	nImpA, nImpB := uint32(a.NumImportedFuncs()), uint32(b.NumImportedFuncs())
+	impA, nUnresA := unresolvedIndices(nImpA, resA)
+	impB, nUnresB := unresolvedIndices(nImpB, resB)
+	baseA := nUnresA + nUnresB
+	baseB := baseA + uint32(a.NumFunctions()) - nImpA
+	fa := func(i uint32) uint32 {
+		switch t, ok := resA[i]; {
+		case ok:
+			return baseB + t - nImpB
+		case i < nImpA:
+			return impA[i]
+		}
+		return baseA + i - nImpA
+	}
+	fb := func(i uint32) uint32 {
+		switch t, ok := resB[i]; {
+		case ok:
+			return baseA + t - nImpA
+		case i < nImpB:
+			return nUnresA + impB[i]
+		}
+		return baseB + i - nImpB
+	}
+
+	m := a
+	if err := m.remapFuncs(fa); err != nil {
+		return Module{}, err
+	}
+	if err := b.remapFuncs(fb); err != nil {
+		return Module{}, err
+	}
+	types := make([]uint32, len(cb.types))
+	for i := range cb.types {
+		types[i] = m.typeIndex(cb.types[i])
+	}
+	if err := b.remapTypes(func(i uint32) uint32 { return types[i] }); err != nil {
+		return Module{}, err
+	}
+
+	var imports []ImportEntry
+	imports = appendUnresolved(imports, m, resA)
+	imports = appendUnresolved(imports, b, resB)
+	if pos := m.sectionIndex(ImportID); pos >= 0 && len(imports) == 0 {
+		m.Sections = append(m.Sections[:pos:pos], m.Sections[pos+1:]...)
+	} else if len(imports) > 0 {
+		m.setSection(ImportSection{Imports: imports})
+	}
+
+	fsA, _ := m.section(FunctionID).(FunctionSection)
+	fsB, _ := b.section(FunctionID).(FunctionSection)
+	if len(fsB.Types) > 0 {
+		funcs := append(append([]uint32{}, fsA.Types...), fsB.Types...)
+		m.setSection(FunctionSection{Types: funcs})
+		csA, _ := m.section(CodeID).(CodeSection)
+		csB, _ := b.section(CodeID).(CodeSection)
+		bodies := append(append([]FunctionBody{}, csA.Bodies...), csB.Bodies...)
+		m.setSection(CodeSection{Bodies: bodies})
+	}
+
+	esA, _ := m.section(ExportID).(ExportSection)
+	esB, _ := b.section(ExportID).(ExportSection)
+	if len(esB.Exports) > 0 {
+		names := make(map[string]bool)
+		exports := append(append([]ExportEntry{}, esA.Exports...), esB.Exports...)
+		for _, ee := range exports {
+			if names[ee.Field] {
+				return Module{}, fmt.Errorf("%w: %s", errDupExport, ee.Field)
+			}
+			names[ee.Field] = true
+		}
+		m.setSection(ExportSection{Exports: exports})
+	}
+	return m, nil
+}
+
+// resolveImports maps the function imports of m to the functions defined
+// and exported by other under the same name.
+func resolveImports(m Module, mc moduleContext, other Module, oc moduleContext) (map[uint32]uint32, error) {
+	nImp := uint32(other.NumImportedFuncs())
+	exports := make(map[string]uint32)
+	if es, ok := other.section(ExportID).(ExportSection); ok {
+		for _, ee := range es.Exports {
+			if ee.Kind == FunctionKind && ee.Index >= nImp {
+				exports[ee.Field] = ee.Index
+			}
+		}
+	}
+	res := make(map[uint32]uint32)
+	is, _ := m.section(ImportID).(ImportSection)
+	idx := uint32(0)
+	for _, imp := range is.Imports {
+		if imp.Kind != FunctionKind {
+			continue
+		}
+		if target, ok := exports[imp.Field]; ok {
+			ft, ot := mc.funcType(idx), oc.funcType(target)
+			if ft == nil || ot == nil || !ft.equal(ot) {
+				return nil, fmt.Errorf("%w: %s", errLinkSig, imp.Field)
+			}
+			res[idx] = target
+		}
+		idx++
+	}
+	return res, nil
+}
+
+// unresolvedIndices numbers the function imports left unresolved.
+func unresolvedIndices(nImp uint32, res map[uint32]uint32) ([]uint32, uint32) {
+	idx := make([]uint32, nImp)
+	n := uint32(0)
+	for i := range idx {
+		if _, ok := res[uint32(i)]; !ok {
+			idx[i] = n
+			n++
+		}
+	}
+	return idx, n
+}
+
+// appendUnresolved appends the imports of m but the resolved functions.
+func appendUnresolved(imports []ImportEntry, m Module, res map[uint32]uint32) []ImportEntry {
+	is, _ := m.section(ImportID).(ImportSection)
+	idx := uint32(0)
+	for _, imp := range is.Imports {
+		if imp.Kind == FunctionKind {
+			_, resolved := res[idx]
+			idx++
+			if resolved {
+				continue
+			}
+		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
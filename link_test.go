@@ -0,0 +1,103 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	i32 := []ValueType{ValueI32}
+	addSig := NewFuncType([]ValueType{ValueI32, ValueI32}, i32)
+
+	var ba ModuleBuilder
+	log := ba.AddImportFunc("env", "log", NewFuncType(i32, nil))
+	add := ba.AddImportFunc("env", "add", addSig)
+	run := ba.AddFunc(NewFuncType(nil, nil), FunctionBody{Code: []byte{
+		0x41, 0x01, // i32.const 1
+		0x41, 0x02, // i32.const 2
+		0x10, byte(add), // call add
+		0x10, byte(log), // call log
+		0x0b,
+	}})
+	ba.AddExport("run", FunctionKind, run)
+	a, err := ba.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bb ModuleBuilder
+	double := bb.AddFunc(NewFuncType(i32, i32), FunctionBody{Code: []byte{
+		0x20, 0x00, // local.get 0
+		0x20, 0x00, // local.get 0
+		0x6a, // i32.add
+		0x0b,
+	}})
+	bb.AddExport("add", FunctionKind, bb.AddFunc(addSig, FunctionBody{Code: []byte{
+		0x20, 0x00, // local.get 0
+		0x20, 0x01, // local.get 1
+		0x6a,               // i32.add
+		0x10, byte(double), // call double
+		0x0b,
+	}}))
+	b, err := bb.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Link(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = reparse(t, m)
+	if err := m.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	if err := m.TypeCheck(); err != nil {
+		t.Errorf("TypeCheck: %v", err)
+	}
+
+	// log stays imported, run is 1, double 2 and add 3
+	is := m.section(ImportID).(ImportSection)
+	if len(is.Imports) != 1 || is.Imports[0].Field != "log" {
+		t.Errorf("got imports %+v, want env.log only", is.Imports)
+	}
+	wantCalls := map[uint32][]uint32{1: {3, 0}, 3: {2}}
+	for fn, want := range wantCalls {
+		fb, err := m.funcBody(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		insns, err := fb.Instructions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var calls []uint32
+		for _, ins := range insns {
+			if ins.Op == Op_call {
+				calls = append(calls, ins.Index)
+			}
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(want) {
+			t.Errorf("function %d calls %v, want %v", fn, calls, want)
+		}
+	}
+	if got := m.ExportsOf(FunctionKind, 3); len(got) != 1 || got[0] != "add" {
+		t.Errorf("function 3 exported as %q, want [add]", got)
+	}
+
+	// an import resolved to a function of another signature
+	var bc ModuleBuilder
+	bc.AddExport("add", FunctionKind, bc.AddFunc(NewFuncType(i32, i32), FunctionBody{Code: []byte{0x20, 0x00, 0x0b}}))
+	c, err := bc.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Link(a, c); !errors.Is(err, errLinkSig) {
+		t.Errorf("signature mismatch: got %v, want %v", err, errLinkSig)
+	}
+}
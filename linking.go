@@ -0,0 +1,297 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// linkingVersion is the version of the "linking" section decoded by
+// ParseLinking.
+const linkingVersion = 2
+
+// linking subsection types
+const (
+	linkingSegmentInfo = 5
+	linkingInitFuncs   = 6
+	linkingSymbolTable = 8
+)
+
+// LinkingInfo is the content of the "linking" custom section of a
+// relocatable object file.
+type LinkingInfo struct {
+	Version   uint32
+	Segments  []SegmentInfo // data segments
+	InitFuncs []InitFunc    // functions to run at startup
+	Symbols   []SymbolInfo  // symbol table
+}
+
+// SegmentInfo describes a data segment of a relocatable object.
+type SegmentInfo struct {
+	Name  string
+	Align uint32 // alignment of the segment, as a power of two
+	Flags uint32
+}
+
+// InitFunc is a function to run at startup, by increasing priority.
+type InitFunc struct {
+	Priority uint32
+	Symbol   uint32 // index of the function in the symbol table
+}
+
+// SymbolKind is the kind of entity a symbol refers to.
+type SymbolKind byte
+
+const (
+	SymbolFunction SymbolKind = 0
+	SymbolData     SymbolKind = 1
+	SymbolGlobal   SymbolKind = 2
+	SymbolSection  SymbolKind = 3
+	SymbolTag      SymbolKind = 4
+	SymbolTable    SymbolKind = 5
+)
+
+func (k SymbolKind) String() string {
+	switch k {
+	case SymbolFunction:
+		return "function"
+	case SymbolData:
+		return "data"
+	case SymbolGlobal:
+		return "global"
+	case SymbolSection:
+		return "section"
+	case SymbolTag:
+		return "tag"
+	case SymbolTable:
+		return "table"
+	}
+	return fmt.Sprintf("SymbolKind(%d)", byte(k))
+}
+
+// Symbol flags
+const (
+	SymbolWeak         = 0x01
+	SymbolLocal        = 0x02
+	SymbolHidden       = 0x04
+	SymbolUndefined    = 0x10
+	SymbolExported     = 0x20
+	SymbolExplicitName = 0x40
+	SymbolNoStrip      = 0x80
+)
+
+// SymbolInfo is an entry of the symbol table of a relocatable object.
+type SymbolInfo struct {
+	Kind  SymbolKind
+	Flags uint32
+	Name  string // empty for undefined symbols using the name of their import
+	Index uint32 // index of the entity, or of the data segment of a data symbol
+	// Offset and Size locate a defined data symbol within its segment.
+	Offset uint32
+	Size   uint32
+}
+
+// Undefined reports whether the symbol refers to an imported entity.
+func (s SymbolInfo) Undefined() bool {
+	return s.Flags&SymbolUndefined != 0
+}
+
+// ParseLinking decodes the payload of a "linking" section, the COMDAT and
+// unknown subsections are skipped.
+func ParseLinking(payload []byte) (LinkingInfo, error) {
+	var info LinkingInfo
+	d, r := customDecoder(payload)
+	d.readVarU32(r, &info.Version)
+	if d.err == nil && info.Version != linkingVersion {
+		return info, fmt.Errorf("wasm: unsupported linking section version %d", info.Version)
+	}
+	for d.err == nil && r.Len() > 0 {
+		typ, sub := d.subsection(r, payload)
+		sd, sr := customDecoder(sub)
+		var n uint32
+		switch typ {
+		case linkingSegmentInfo:
+			sd.readCount(sr, &n)
+			if sd.err != nil {
+				break
+			}
+			info.Segments = make([]SegmentInfo, int(n))
+			for i := range info.Segments {
+				seg := &info.Segments[i]
+				sd.readString(sr, &seg.Name)
+				sd.readVarU32(sr, &seg.Align)
+				sd.readVarU32(sr, &seg.Flags)
+			}
+		case linkingInitFuncs:
+			sd.readCount(sr, &n)
+			if sd.err != nil {
+				break
+			}
+			info.InitFuncs = make([]InitFunc, int(n))
+			for i := range info.InitFuncs {
+				sd.readVarU32(sr, &info.InitFuncs[i].Priority)
+				sd.readVarU32(sr, &info.InitFuncs[i].Symbol)
+			}
+		case linkingSymbolTable:
+			sd.readCount(sr, &n)
+			if sd.err != nil {
+				break
+			}
+			info.Symbols = make([]SymbolInfo, int(n))
+			for i := range info.Symbols {
+				sd.readSymbolInfo(sr, &info.Symbols[i])
+			}
+		default:
+			continue
+		}
+		if err := customErr(sd, sr); err != nil {
+			return info, err
+		}
+	}
+	return info, customErr(d, r)
+}
+
+func (d *decoder) readSymbolInfo(r io.Reader, sym *SymbolInfo) {
+	var kind [1]byte
+	d.read(r, kind[:])
+	sym.Kind = SymbolKind(kind[0])
+	d.readVarU32(r, &sym.Flags)
+	switch sym.Kind {
+	case SymbolFunction, SymbolGlobal, SymbolTag, SymbolTable:
+		d.readVarU32(r, &sym.Index)
+		if !sym.Undefined() || sym.Flags&SymbolExplicitName != 0 {
+			d.readString(r, &sym.Name)
+		}
+	case SymbolData:
+		d.readString(r, &sym.Name)
+		if !sym.Undefined() {
+			d.readVarU32(r, &sym.Index)
+			d.readVarU32(r, &sym.Offset)
+			d.readVarU32(r, &sym.Size)
+		}
+	case SymbolSection:
+		d.readVarU32(r, &sym.Index)
+	default:
+		if d.err == nil {
+			d.err = errCustom
+		}
+	}
+}
+
+// Linking returns the decoded "linking" section, it reports false if the
+// module has none or it is malformed.
+func (m Module) Linking() (LinkingInfo, bool) {
+	payload, ok := m.customPayload("linking")
+	if !ok {
+		return LinkingInfo{}, false
+	}
+	info, err := ParseLinking(payload)
+	return info, err == nil
+}
+
+// RelocType is the type of a relocation entry.
+type RelocType byte
+
+// Relocation types
+const (
+	RelocFunctionIndexLEB    RelocType = 0
+	RelocTableIndexSLEB      RelocType = 1
+	RelocTableIndexI32       RelocType = 2
+	RelocMemoryAddrLEB       RelocType = 3
+	RelocMemoryAddrSLEB      RelocType = 4
+	RelocMemoryAddrI32       RelocType = 5
+	RelocTypeIndexLEB        RelocType = 6
+	RelocGlobalIndexLEB      RelocType = 7
+	RelocFunctionOffsetI32   RelocType = 8
+	RelocSectionOffsetI32    RelocType = 9
+	RelocTagIndexLEB         RelocType = 10
+	RelocMemoryAddrRelSLEB   RelocType = 11
+	RelocTableIndexRelSLEB   RelocType = 12
+	RelocGlobalIndexI32      RelocType = 13
+	RelocMemoryAddrLEB64     RelocType = 14
+	RelocMemoryAddrSLEB64    RelocType = 15
+	RelocMemoryAddrI64       RelocType = 16
+	RelocMemoryAddrRelSLEB64 RelocType = 17
+	RelocTableIndexSLEB64    RelocType = 18
+	RelocTableIndexI64       RelocType = 19
+	RelocTableNumberLEB      RelocType = 20
+	RelocMemoryAddrTLSSLEB   RelocType = 21
+	RelocFunctionOffsetI64   RelocType = 22
+	RelocMemoryAddrLocRelI32 RelocType = 23
+	RelocTableIndexRelSLEB64 RelocType = 24
+	RelocMemoryAddrTLSSLEB64 RelocType = 25
+	RelocFunctionIndexI32    RelocType = 26
+)
+
+// HasAddend reports whether relocations of type t carry an addend.
+func (t RelocType) HasAddend() bool {
+	switch t {
+	case RelocMemoryAddrLEB, RelocMemoryAddrSLEB, RelocMemoryAddrI32,
+		RelocFunctionOffsetI32, RelocSectionOffsetI32, RelocMemoryAddrRelSLEB,
+		RelocMemoryAddrLEB64, RelocMemoryAddrSLEB64, RelocMemoryAddrI64,
+		RelocMemoryAddrRelSLEB64, RelocMemoryAddrTLSSLEB, RelocFunctionOffsetI64,
+		RelocMemoryAddrLocRelI32, RelocMemoryAddrTLSSLEB64:
+		return true
+	}
+	return false
+}
+
+// Reloc is a relocation entry.
+type Reloc struct {
+	Type   RelocType
+	Offset uint32 // offset of the value to rewrite within the section payload
+	Index  uint32 // symbol index, or type index for RelocTypeIndexLEB
+	Addend int32  // only set for the types with an addend
+}
+
+// RelocSection is the content of a "reloc.*" custom section.
+type RelocSection struct {
+	Section uint32 // index of the section the relocations apply to
+	Relocs  []Reloc
+}
+
+// ParseReloc decodes the payload of a "reloc.*" section.
+func ParseReloc(payload []byte) (RelocSection, error) {
+	var rs RelocSection
+	d, r := customDecoder(payload)
+	d.readVarU32(r, &rs.Section)
+	var n uint32
+	d.readCount(r, &n)
+	if d.err != nil {
+		return rs, customErr(d, r)
+	}
+	rs.Relocs = make([]Reloc, int(n))
+	for i := range rs.Relocs {
+		rel := &rs.Relocs[i]
+		var typ [1]byte
+		d.read(r, typ[:])
+		rel.Type = RelocType(typ[0])
+		d.readVarU32(r, &rel.Offset)
+		d.readVarU32(r, &rel.Index)
+		if rel.Type.HasAddend() {
+			d.readVarI32(r, &rel.Addend)
+		}
+	}
+	return rs, customErr(d, r)
+}
+
+// Relocs returns the decoded "reloc.*" sections of the module, in order.
+func (m Module) Relocs() ([]RelocSection, error) {
+	var relocs []RelocSection
+	for _, s := range m.Sections {
+		ns, ok := s.(NameSection)
+		if !ok || !strings.HasPrefix(ns.Name, "reloc.") {
+			continue
+		}
+		rs, err := ParseReloc(ns.Payload)
+		if err != nil {
+			return relocs, err
+		}
+		relocs = append(relocs, rs)
+	}
+	return relocs, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"testing"
+)
+
+// testdata/object.o is assembled from testdata/object.s with:
+//   llvm-mc -triple=wasm32-unknown-unknown -filetype=obj object.s -o object.o
+
+func TestLinking(t *testing.T) {
+	mod, err := Open("testdata/object.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := mod.Linking()
+	if !ok {
+		t.Fatal("linking section missing or malformed")
+	}
+	if info.Version != 2 {
+		t.Errorf("Version = %d, want 2", info.Version)
+	}
+
+	want := []SymbolInfo{
+		{Kind: SymbolFunction, Name: "add", Index: 1},
+		{Kind: SymbolFunction, Flags: SymbolUndefined, Index: 0},
+		{Kind: SymbolData, Name: "counter", Index: 0, Size: 4},
+		{Kind: SymbolFunction, Flags: SymbolHidden, Name: "main", Index: 2},
+		{Kind: SymbolData, Name: "ptr", Index: 1, Size: 4},
+	}
+	if len(info.Symbols) != len(want) {
+		t.Fatalf("got %d symbols %+v, want %d", len(info.Symbols), info.Symbols, len(want))
+	}
+	for i, sym := range info.Symbols {
+		if sym != want[i] {
+			t.Errorf("symbol %d: got %+v, want %+v", i, sym, want[i])
+		}
+	}
+	if !info.Symbols[1].Undefined() || info.Symbols[0].Undefined() {
+		t.Error("Undefined() does not match the symbol flags")
+	}
+
+	if len(info.Segments) != 2 || info.Segments[0].Name != ".data.counter" || info.Segments[1].Align != 2 {
+		t.Errorf("got segments %+v, want .data.counter and .data.ptr aligned on 4", info.Segments)
+	}
+
+	payload := mod.CustomSections("linking")[0]
+	if _, err := ParseLinking(payload[:len(payload)-1]); err != errCustom {
+		t.Errorf("truncated payload: got %v, want %v", err, errCustom)
+	}
+	if _, ok := withCustom(t, "other", payload).Linking(); ok {
+		t.Error("Linking() reports a section for a module without linking")
+	}
+}
+
+func TestRelocs(t *testing.T) {
+	mod, err := Open("testdata/object.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relocs, err := mod.Relocs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RelocSection{
+		{Section: 4, Relocs: []Reloc{
+			{Type: RelocFunctionIndexLEB, Offset: 9, Index: 1},
+			{Type: RelocMemoryAddrLEB, Offset: 18, Index: 2},
+			{Type: RelocFunctionIndexLEB, Offset: 32, Index: 0},
+		}},
+		{Section: 5, Relocs: []Reloc{
+			{Type: RelocMemoryAddrI32, Offset: 15, Index: 2, Addend: 4},
+		}},
+	}
+	if len(relocs) != len(want) {
+		t.Fatalf("got %d reloc sections, want %d", len(relocs), len(want))
+	}
+	for i, rs := range relocs {
+		if rs.Section != want[i].Section || len(rs.Relocs) != len(want[i].Relocs) {
+			t.Errorf("reloc section %d: got %+v, want %+v", i, rs, want[i])
+			continue
+		}
+		for j, rel := range rs.Relocs {
+			if rel != want[i].Relocs[j] {
+				t.Errorf("reloc section %d, entry %d: got %+v, want %+v", i, j, rel, want[i].Relocs[j])
+			}
+		}
+	}
+
+	payload := mod.CustomSections("reloc.DATA")[0]
+	if _, err := ParseReloc(payload[:len(payload)-1]); err != errCustom {
+		t.Errorf("truncated payload: got %v, want %v", err, errCustom)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import "fmt"
+
+// LintIssue is a non-fatal issue of a module reported by Lint.
+type LintIssue struct {
+	Code    string // short identifier of the kind of issue
+	Message string
+}
+
+func (li LintIssue) String() string {
+	return li.Code + ": " + li.Message
+}
+
+// Lint codes
+const (
+	LintMutableGlobalExport = "mutable-global-export"
+	LintUnusedType          = "unused-type"
+	LintNonMinimalLEB128    = "non-minimal-leb128"
+	LintNoNameSection       = "no-name-section"
+	LintFloatingPoint       = "floating-point"
+)
+
+// Lint returns the issues of the module which are valid but against best
+// practice: exported mutable globals, unused types, non-minimal LEB128
+// encodings, a missing "name" section and the use of floating point, which
+// is not deterministic. The encodings of the sections are checked as they
+// were decoded, over-long LEB128 are recorded by a non-strict decoder.
+func (m Module) Lint() []LintIssue {
+	var issues []LintIssue
+	add := func(code, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	mc := m.context()
+	if es, ok := m.section(ExportID).(ExportSection); ok {
+		for _, ee := range es.Exports {
+			if ee.Kind == GlobalKind && int64(ee.Index) < int64(len(mc.globals)) &&
+				mc.globals[ee.Index].Mutability != 0 {
+				add(LintMutableGlobalExport, "global %d is exported mutable as %q", ee.Index, ee.Field)
+			}
+		}
+	}
+
+	for _, t := range m.UnusedTypes() {
+		add(LintUnusedType, "type %d is not used", t)
+	}
+
+	for _, s := range m.Sections {
+		if rs, ok := s.(interface{ overlongLEB128() bool }); ok && rs.overlongLEB128() {
+			add(LintNonMinimalLEB128, "%s section is not minimally encoded", s.ID())
+		}
+	}
+	if code, ok := m.section(CodeID).(CodeSection); ok {
+		for i, fb := range code.Bodies {
+			var d decoder
+			if _, _, err := d.readCode(fb.Code); err == nil && d.overlong {
+				add(LintNonMinimalLEB128, "code of function %d is not minimally encoded", m.AbsoluteFuncIndex(i))
+			}
+		}
+	}
+
+	hasNames := false
+	for _, s := range m.Sections {
+		if ns, ok := s.(NameSection); ok && ns.Name == "name" {
+			hasNames = true
+		}
+	}
+	if !hasNames {
+		add(LintNoNameSection, "the module has no \"name\" section")
+	}
+
+	if fp, err := m.UsesFloatingPoint(); err == nil && fp {
+		add(LintFloatingPoint, "the module uses floating point")
+	}
+	return issues
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	// i32.const 0 encoded on 2 bytes; drop; end
+	mod := codeModule(0x41, 0x80, 0x00, 0x1a, 0x0b)
+	mod.Sections[0] = TypeSection{Types: []FuncType{
+		{form: ValueFunc},
+		{form: ValueFunc, params: []ValueType{ValueI32}},
+	}}
+	mod.addSection(GlobalSection{globals: []GlobalVariable{
+		{Type: GlobalType{ContentType: ValueF64, Mutability: 1}, Init: InitExpr{Op: Op_f64_const}},
+	}})
+	if err := mod.AddExport("counter", GlobalKind, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+	for _, li := range mod.Lint() {
+		codes = append(codes, li.Code)
+		if li.Message == "" {
+			t.Errorf("%s: empty message", li.Code)
+		}
+	}
+	sort.Strings(codes)
+	want := []string{LintFloatingPoint, LintMutableGlobalExport, LintNoNameSection,
+		LintNonMinimalLEB128, LintUnusedType}
+	if strings.Join(codes, ",") != strings.Join(want, ",") {
+		t.Errorf("got issues %v, want %v", codes, want)
+	}
+
+	// a padded section size is recorded by the decoder
+	padded := append(append([]byte{}, wasmHeader...),
+		0x01, 0x84, 0x80, 0x80, 0x80, 0x00, 0x01, 0x60, 0x00, 0x00)
+	mod, err := Parse(padded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.SetModuleName("padded")
+	issues := mod.Lint()
+	if len(issues) != 2 || issues[0].Code != LintUnusedType || issues[1].Code != LintNonMinimalLEB128 {
+		t.Errorf("got issues %v, want an unused type and a non-minimal type section", issues)
+	}
+
+	// a minimal section which does not re-encode to the same bytes, the
+	// function names of its two subsections are merged
+	names := append(append([]byte{}, wasmHeader...),
+		0x00, 0x11, 0x04, 'n', 'a', 'm', 'e',
+		0x01, 0x04, 0x01, 0x00, 0x01, 'f',
+		0x01, 0x04, 0x01, 0x01, 0x01, 'g')
+	if mod, err = ParseWith(names, Options{KeepRaw: true}); err != nil {
+		t.Fatal(err)
+	}
+	if issues := mod.Lint(); len(issues) != 0 {
+		t.Errorf("got issues %v, want none", issues)
+	}
+}
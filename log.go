@@ -0,0 +1,45 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"log"
+	"sync"
+)
+
+// Logger receives the warnings of the package.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger logs to the standard logger of the log package.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = stdLogger{}
+)
+
+// SetLogger sets the logger of the package, a nil l restores the default
+// which uses the standard logger of the log package.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+func logf(format string, v ...interface{}) {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	l.Printf(format, v...)
+}
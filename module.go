@@ -6,6 +6,8 @@ package wasm
 
 import (
 	"fmt"
+	"math"
+	"sort"
 )
 
 // Module is a WebAssembly module.
@@ -23,9 +25,25 @@ func (hdr ModuleHeader) String() string {
 	return fmt.Sprintf("ModuleHeader{Magic=%q Version=0x%x}", hdr.Magic, hdr.Version)
 }
 
+// Bytes returns the 8 bytes of the encoded header, the magic number followed
+// by the little-endian version.
+func (hdr ModuleHeader) Bytes() []byte {
+	b := make([]byte, 8)
+	copy(b, hdr.Magic[:])
+	order.PutUint32(b[4:], hdr.Version)
+	return b
+}
+
+// HeaderBytes returns the encoded header of the module, the prefix of its
+// encoding.
+func (m Module) HeaderBytes() []byte {
+	return m.Header.Bytes()
+}
+
 // Section represents a section in a wasm module.
 type Section interface {
 	ID() SectionID
+	Size() int // size of the encoded section body, zero if it cannot be encoded
 }
 
 // SectionID represents the specific kind of section that a Section represents.
@@ -44,6 +62,9 @@ const (
 	ElementID            = 9  // Elements section
 	CodeID               = 10 // Function bodies (code)
 	DataID               = 11 // Data segments
+	TagID                = 13 // Exception tags (exception-handling proposal)
+
+	DataCountID SectionID = 12 // Number of data segments (bulk-memory proposal)
 )
 
 func (TypeSection) ID() SectionID     { return TypeID }
@@ -58,12 +79,38 @@ func (ElementSection) ID() SectionID  { return ElementID }
 func (CodeSection) ID() SectionID     { return CodeID }
 func (DataSection) ID() SectionID     { return DataID }
 func (NameSection) ID() SectionID     { return UnknownID }
+func (TagSection) ID() SectionID      { return TagID }
+
+func (s TypeSection) Size() int     { return s.sizeOf(s) }
+func (s ImportSection) Size() int   { return s.sizeOf(s) }
+func (s FunctionSection) Size() int { return s.sizeOf(s) }
+func (s TableSection) Size() int    { return s.sizeOf(s) }
+func (s MemorySection) Size() int   { return s.sizeOf(s) }
+func (s GlobalSection) Size() int   { return s.sizeOf(s) }
+func (s ExportSection) Size() int   { return s.sizeOf(s) }
+func (s StartSection) Size() int    { return s.sizeOf(s) }
+func (s ElementSection) Size() int  { return s.sizeOf(s) }
+func (s DataSection) Size() int     { return s.sizeOf(s) }
+func (s NameSection) Size() int     { return s.sizeOf(s) }
+func (s TagSection) Size() int      { return s.sizeOf(s) }
+
+func (DataCountSection) ID() SectionID { return DataCountID }
+func (s DataCountSection) Size() int   { return s.sizeOf(s) }
+
+func (s CodeSection) Size() int {
+	if s.Skipped != 0 {
+		return s.Skipped
+	}
+	return s.sizeOf(s)
+}
 
 type TypeSection struct {
-	Types []FuncType // type entries
+	rawSection
+	Types []FuncType // type entries, a rec group is a single entry
 }
 
 type ImportSection struct {
+	rawSection
 	Imports []ImportEntry
 }
 
@@ -85,21 +132,25 @@ type ImportEntry struct {
 
 // FunctionSection declares the signature of all functions in the module
 type FunctionSection struct {
+	rawSection
 	Types []uint32 // indices into the type sections
 }
 
 // TableSection encodes a table
 type TableSection struct {
+	rawSection
 	tables []TableType
 }
 
 // MemorySection encodes a memory
 type MemorySection struct {
+	rawSection
 	memories []MemoryType
 }
 
 // GlobalSection encodes the global section
 type GlobalSection struct {
+	rawSection
 	globals []GlobalVariable
 }
 
@@ -110,8 +161,24 @@ type GlobalVariable struct {
 	Init InitExpr   // initial value of the global
 }
 
+// Values returns the constant initial value of each global of the section
+// and whether it has one, the globals initialized by get_global or by
+// v128.const have none.
+func (s GlobalSection) Values() ([]int64, []bool) {
+	values := make([]int64, len(s.globals))
+	ok := make([]bool, len(s.globals))
+	for i, gv := range s.globals {
+		switch gv.Init.Op {
+		case Op_unreachable, Op_i32_const, Op_i64_const, Op_f32_const, Op_f64_const:
+			values[i], ok[i] = gv.Init.Value, true
+		}
+	}
+	return values, ok
+}
+
 // ExportSection encodes the export section
 type ExportSection struct {
+	rawSection
 	Exports []ExportEntry
 }
 
@@ -124,11 +191,13 @@ type ExportEntry struct {
 
 // StartSection declares the start function
 type StartSection struct {
+	rawSection
 	Index uint32 // start function index
 }
 
 // ElementSection encodes the elements section
 type ElementSection struct {
+	rawSection
 	elements []ElemSegment
 }
 
@@ -136,6 +205,14 @@ type ElemSegment struct {
 	Index  uint32   // the table index
 	Offset InitExpr // an i32 initializer expression that computes the offset at which to place the elements
 	Elems  []uint32 // sequence of function indices
+
+	// Flags selects the encoding of the segment of the bulk-memory and
+	// reference-types proposals, zero for an MVP segment: bit 0x1 is set for
+	// a passive or declarative segment, 0x2 for an explicit table index or a
+	// declarative segment, 0x4 for elements given as expressions in Exprs.
+	Flags uint32
+	Type  ElemType   // element type, encoded if Flags&0x3 != 0
+	Exprs []InitExpr // ref.func or ref.null elements, if Flags&0x4 != 0
 }
 
 // CodeSection contains a body for every function in the module.
@@ -143,11 +220,18 @@ type ElemSegment struct {
 // defined in this section must be the same and the i-th declaration corresponds
 // to the i-th function body.
 type CodeSection struct {
-	Bodies []FunctionBody
+	rawSection
+	Bodies  []FunctionBody
+	Skipped int // size of the section when the bodies were skipped (Options.SkipCode)
+
+	// offsets holds the offsets in the module of the decoded bodies, it is
+	// not updated when the section is modified.
+	offsets []int64
 }
 
 // DataSection declares the initialized data that is loaded into linear memory
 type DataSection struct {
+	rawSection
 	segments []DataSegment
 }
 
@@ -157,12 +241,41 @@ type DataSegment struct {
 	Data   []byte
 }
 
+// DataCountSection declares the number of data segments ahead of the code
+// section (bulk-memory proposal)
+type DataCountSection struct {
+	rawSection
+	Count uint32
+}
+
+// TagSection declares the exception tags of the exception-handling proposal
+type TagSection struct {
+	rawSection
+	Tags []TagType
+}
+
+// TagType describes an exception tag
+type TagType struct {
+	Attribute byte   // 0: exception
+	Type      uint32 // index of the tag signature in the type section
+}
+
 // NameSection describes user-defined sections
 type NameSection struct {
+	rawSection
 	Name     string
-	Size     int
 	ModName  string
 	FuncName []FunctionNames
+	// Subsections holds, in order, the subsections of the "name" section
+	// other than the module and function names, such as the local names.
+	Subsections []NameSubsection
+	Payload     []byte // raw payload of custom sections other than "name"
+}
+
+// NameSubsection is a subsection of the "name" section kept undecoded.
+type NameSubsection struct {
+	ID      byte
+	Payload []byte
 }
 
 type FunctionNames struct {
@@ -181,3 +294,376 @@ type LocalEntry struct {
 	Count uint32    // number of local variables of the following type
 	Type  ValueType // type of the variables
 }
+
+// rawSection holds the encoded bytes of a decoded section.
+type rawSection struct {
+	// Raw is the section id, size and payload as read when decoding with
+	// Options.KeepRaw, it is not updated when the section is modified.
+	Raw []byte
+
+	overlong bool    // the section was decoded with an over-long LEB128
+	off      int64   // offset in the module of the section, zero if not decoded
+	entries  []int64 // offsets in the module of the decoded entries
+
+	// size is the size of the decoded section body, it is reset by the
+	// methods of Module which modify the section.
+	size int
+}
+
+// RawBytes returns the encoded bytes of the section, see Raw.
+func (s rawSection) RawBytes() []byte { return s.Raw }
+
+func (s rawSection) overlongLEB128() bool { return s.overlong }
+
+// sizeOf returns the size of the body of sec, which embeds s, as decoded or
+// else of its encoding.
+func (s rawSection) sizeOf(sec Section) int {
+	if s.size != 0 {
+		return s.size
+	}
+	return sectionSize(sec)
+}
+
+// offsetOf returns the offset in the module of entry i of the section, or of
+// the section itself if the entry was not recorded.
+func (s rawSection) offsetOf(i int) int64 {
+	if i >= 0 && i < len(s.entries) {
+		return s.entries[i]
+	}
+	return s.off
+}
+
+// section returns the first section of m with the given id, or nil.
+func (m Module) section(id SectionID) Section {
+	for _, s := range m.Sections {
+		if s.ID() == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// Upto returns the module truncated after the first section of the given
+// id, or the whole module if it has none.
+func (m Module) Upto(id SectionID) Module {
+	for i, s := range m.Sections {
+		if s.ID() == id {
+			m.Sections = append([]Section(nil), m.Sections[:i+1]...)
+			break
+		}
+	}
+	return m
+}
+
+// Has reports whether the module holds a section of the given id.
+func (m Module) Has(id SectionID) bool {
+	return m.section(id) != nil
+}
+
+// HasCustom reports whether the module holds a custom section called name.
+func (m Module) HasCustom(name string) bool {
+	for _, s := range m.Sections {
+		if ns, ok := s.(NameSection); ok && ns.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NumImportedFuncs returns the number of functions imported by the module.
+func (m Module) NumImportedFuncs() int {
+	s, ok := m.section(ImportID).(ImportSection)
+	if !ok {
+		return 0
+	}
+	n := 0
+	for _, imp := range s.Imports {
+		if imp.Kind == FunctionKind {
+			n++
+		}
+	}
+	return n
+}
+
+// NumFunctions returns the size of the function index space, ie: the
+// imported functions followed by the functions defined in the module.
+func (m Module) NumFunctions() int {
+	n := m.NumImportedFuncs()
+	if s, ok := m.section(FunctionID).(FunctionSection); ok {
+		n += len(s.Types)
+	}
+	return n
+}
+
+// FunctionCount returns the number of imported functions and the number of
+// functions declared by the function section, see CheckFunctionCount.
+func (m Module) FunctionCount() (imported, defined int) {
+	if s, ok := m.section(FunctionID).(FunctionSection); ok {
+		defined = len(s.Types)
+	}
+	return m.NumImportedFuncs(), defined
+}
+
+// CheckFunctionCount reports an error if the function section and the code
+// section disagree on the number of defined functions. A skipped code
+// section is not checked.
+func (m Module) CheckFunctionCount() error {
+	_, defined := m.FunctionCount()
+	code, _ := m.section(CodeID).(CodeSection)
+	if defined != len(code.Bodies) && code.Skipped == 0 {
+		return m.invalid(CodeID, -1, errFuncCount)
+	}
+	return nil
+}
+
+// DefinedFuncIndex maps the absolute function index abs to an index into
+// CodeSection.Bodies. It reports false if abs refers to an imported function
+// or is out of range.
+func (m Module) DefinedFuncIndex(abs uint32) (int, bool) {
+	nImp := m.NumImportedFuncs()
+	if int64(abs) < int64(nImp) || int64(abs) >= int64(m.NumFunctions()) {
+		return 0, false
+	}
+	return int(abs) - nImp, true
+}
+
+// AbsoluteFuncIndex maps an index into CodeSection.Bodies to its absolute
+// function index.
+func (m Module) AbsoluteFuncIndex(defined int) uint32 {
+	return uint32(m.NumImportedFuncs() + defined)
+}
+
+// funcBody returns the body of the defined function of absolute index idx.
+func (m Module) funcBody(idx uint32) (*FunctionBody, error) {
+	code, _ := m.section(CodeID).(CodeSection)
+	if code.Skipped != 0 {
+		return nil, errSkippedCode
+	}
+	def, ok := m.DefinedFuncIndex(idx)
+	if !ok || def >= len(code.Bodies) {
+		return nil, errBadIndex
+	}
+	return &code.Bodies[def], nil
+}
+
+// FuncBodyOffset returns the offset in the decoded module of the body of the
+// function of absolute index idx, that is of its size. It reports false if
+// idx refers to an imported function or the module was not decoded.
+func (m Module) FuncBodyOffset(idx uint32) (int64, bool) {
+	code, _ := m.section(CodeID).(CodeSection)
+	def, ok := m.DefinedFuncIndex(idx)
+	if !ok || def >= len(code.offsets) {
+		return 0, false
+	}
+	return code.offsets[def], true
+}
+
+// ModuleName returns the module name recorded in the "name" custom section,
+// it reports false if the module has none.
+func (m Module) ModuleName() (string, bool) {
+	for _, s := range m.Sections {
+		if ns, ok := s.(NameSection); ok && ns.Name == "name" && ns.ModName != "" {
+			return ns.ModName, true
+		}
+	}
+	return "", false
+}
+
+// FunctionName returns the name of function idx recorded in the "name"
+// custom section. The index is absolute, so imported functions are named by
+// the low indices. It reports false if the function has no name.
+func (m Module) FunctionName(idx uint32) (string, bool) {
+	for _, s := range m.Sections {
+		ns, ok := s.(NameSection)
+		if !ok || ns.Name != "name" {
+			continue
+		}
+		for _, fn := range ns.FuncName {
+			if fn.Idx == idx {
+				return fn.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// SetModuleName sets the module name recorded in the "name" custom section,
+// appending a new "name" section if the module has none.
+func (m *Module) SetModuleName(name string) {
+	for i, s := range m.Sections {
+		if ns, ok := s.(NameSection); ok && ns.Name == "name" {
+			ns.ModName = name
+			ns.size = 0
+			m.Sections[i] = ns
+			return
+		}
+	}
+	m.Sections = append(m.Sections, NameSection{Name: "name", ModName: name})
+}
+
+// GlobalInitValue returns the initial value of global globalIdx. It reports
+// false for imported globals, whose value is not known statically, and so
+// for the globals initialized by get_global, which may only refer to them.
+func (m Module) GlobalInitValue(globalIdx uint32) (int64, bool) {
+	init, ok := m.globalInit(globalIdx)
+	if !ok || init.Op == Op_get_global {
+		return 0, false
+	}
+	return init.Value, true
+}
+
+// globalInit returns the initializer of global idx, it reports false for
+// imported or out of range globals.
+func (m Module) globalInit(idx uint32) (InitExpr, bool) {
+	n := uint32(0)
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			if imp.Kind == GlobalKind {
+				n++
+			}
+		}
+	}
+	gs, ok := m.section(GlobalID).(GlobalSection)
+	if !ok || idx < n || int64(idx-n) >= int64(len(gs.globals)) {
+		return InitExpr{}, false
+	}
+	return gs.globals[idx-n].Init, true
+}
+
+// memoryLimits returns the limits of memory idx, imported or defined.
+func (m Module) memoryLimits(idx uint32) (ResizableLimits, bool) {
+	var mems []MemoryType
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			if mt, ok := imp.Typ.(MemoryType); ok {
+				mems = append(mems, mt)
+			}
+		}
+	}
+	if s, ok := m.section(MemoryID).(MemorySection); ok {
+		mems = append(mems, s.memories...)
+	}
+	if int64(idx) >= int64(len(mems)) {
+		return ResizableLimits{}, false
+	}
+	return mems[idx].Limits, true
+}
+
+// MaxMemoryPages returns the maximum number of pages of the memory of the
+// module, imported or defined. It reports false if the module has no memory,
+// its memory is unbounded or its maximum does not fit a uint32, as a 64-bit
+// memory may.
+func (m Module) MaxMemoryPages() (uint32, bool) {
+	limits, ok := m.memoryLimits(0)
+	if !ok || limits.Flags&0x1 == 0 || limits.Maximum > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(limits.Maximum), true
+}
+
+// CustomSections returns the payloads of all custom sections called name,
+// in module order.
+func (m Module) CustomSections(name string) [][]byte {
+	var ret [][]byte
+	for _, s := range m.Sections {
+		ns, ok := s.(NameSection)
+		if !ok || ns.Name != name {
+			continue
+		}
+		if ns.Name == "name" {
+			var e encoder
+			e.writeNameSection(&ns)
+			ret = append(ret, e.buf.Bytes())
+		} else {
+			ret = append(ret, ns.Payload)
+		}
+	}
+	return ret
+}
+
+// DataString is a run of printable ASCII characters found in a data segment.
+type DataString struct {
+	Segment int    // index of the data segment
+	Offset  int    // offset of the string within the segment data
+	Value   string // the characters
+}
+
+// DataStrings returns the runs of at least minLen printable ASCII characters
+// of the data segments, in module order.
+func (m Module) DataStrings(minLen int) []DataString {
+	s, ok := m.section(DataID).(DataSection)
+	if !ok {
+		return nil
+	}
+	if minLen < 1 {
+		minLen = 1
+	}
+	var ret []DataString
+	for i, seg := range s.segments {
+		start := -1
+		for j := 0; j <= len(seg.Data); j++ {
+			if j < len(seg.Data) && seg.Data[j] >= 0x20 && seg.Data[j] < 0x7f {
+				if start < 0 {
+					start = j
+				}
+				continue
+			}
+			if start >= 0 && j-start >= minLen {
+				ret = append(ret, DataString{Segment: i, Offset: start, Value: string(seg.Data[start:j])})
+			}
+			start = -1
+		}
+	}
+	return ret
+}
+
+// ImportModules returns the sorted names of the modules imported from.
+func (m Module) ImportModules() []string {
+	s, ok := m.section(ImportID).(ImportSection)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, imp := range s.Imports {
+		if !seen[imp.Module] {
+			seen[imp.Module] = true
+			names = append(names, imp.Module)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportsOf returns the names of the exports of the entity index of kind.
+func (m Module) ExportsOf(kind ExternalKind, index uint32) []string {
+	s, ok := m.section(ExportID).(ExportSection)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, ee := range s.Exports {
+		if ee.Kind == kind && ee.Index == index {
+			names = append(names, ee.Field)
+		}
+	}
+	return names
+}
+
+// ExportedMemory returns the index and limits of the first exported memory,
+// imported or defined. It reports false if the module exports no memory.
+func (m Module) ExportedMemory() (index uint32, limits ResizableLimits, ok bool) {
+	s, found := m.section(ExportID).(ExportSection)
+	if !found {
+		return 0, ResizableLimits{}, false
+	}
+	for _, ee := range s.Exports {
+		if ee.Kind != MemoryKind {
+			continue
+		}
+		if limits, ok = m.memoryLimits(ee.Index); ok {
+			return ee.Index, limits, true
+		}
+	}
+	return 0, ResizableLimits{}, false
+}
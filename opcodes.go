@@ -4,6 +4,8 @@
 
 package wasm
 
+import "fmt"
+
 // Opcode is a wasm opcode.
 type Opcode byte
 
@@ -230,3 +232,245 @@ const (
 	Op_f32_reinterpret_i32        = 0xbe
 	Op_f64_reinterpret_i64        = 0xbf
 )
+
+// Exception handling operators of the exception-handling proposal
+const (
+	Op_try       Opcode = 0x06
+	Op_catch            = 0x07
+	Op_throw            = 0x08
+	Op_rethrow          = 0x09
+	Op_delegate         = 0x18
+	Op_catch_all        = 0x19
+)
+
+// Reference operators of the reference-types proposal
+const (
+	Op_ref_null    Opcode = 0xd0
+	Op_ref_is_null        = 0xd1
+	Op_ref_func           = 0xd2
+)
+
+// SIMD operators, encoded as Op_simd_prefix followed by a varuint32 opcode
+const (
+	Op_simd_prefix  Opcode = 0xfd
+	Simd_v128_const        = 0x0c
+)
+
+// IsControl reports whether o is a control flow or call operator, those of
+// the exception-handling proposal included.
+func (o Opcode) IsControl() bool {
+	return o <= Op_rethrow || o >= Op_end && o <= Op_call_indirect ||
+		o == Op_delegate || o == Op_catch_all
+}
+
+// IsParametric reports whether o is drop or select.
+func (o Opcode) IsParametric() bool {
+	return o == Op_drop || o == Op_select
+}
+
+// IsVariable reports whether o accesses a local or global variable.
+func (o Opcode) IsVariable() bool {
+	return o >= Op_get_local && o <= Op_set_global
+}
+
+// IsMemory reports whether o is a load, a store or a memory size operator.
+func (o Opcode) IsMemory() bool {
+	return o >= Op_i32_load && o <= Op_grow_memory
+}
+
+// IsNumeric reports whether o is a constant, comparison, numeric,
+// conversion or reinterpretation operator.
+func (o Opcode) IsNumeric() bool {
+	return o >= Op_i32_const && o <= Op_f64_reinterpret_i64
+}
+
+// opNames holds the text format names of the MVP and exception-handling
+// opcodes.
+var opNames = [256]string{
+	Op_unreachable:         "unreachable",
+	Op_nop:                 "nop",
+	Op_block:               "block",
+	Op_loop:                "loop",
+	Op_if:                  "if",
+	Op_else:                "else",
+	Op_try:                 "try",
+	Op_catch:               "catch",
+	Op_throw:               "throw",
+	Op_rethrow:             "rethrow",
+	Op_end:                 "end",
+	Op_br:                  "br",
+	Op_br_if:               "br_if",
+	Op_br_table:            "br_table",
+	Op_return:              "return",
+	Op_delegate:            "delegate",
+	Op_catch_all:           "catch_all",
+	Op_call:                "call",
+	Op_call_indirect:       "call_indirect",
+	Op_drop:                "drop",
+	Op_select:              "select",
+	Op_get_local:           "local.get",
+	Op_set_local:           "local.set",
+	Op_tee_local:           "local.tee",
+	Op_get_global:          "global.get",
+	Op_set_global:          "global.set",
+	Op_i32_load:            "i32.load",
+	Op_i64_load:            "i64.load",
+	Op_f32_load:            "f32.load",
+	Op_f64_load:            "f64.load",
+	Op_i32_load8_s:         "i32.load8_s",
+	Op_i32_load8_u:         "i32.load8_u",
+	Op_i32_load16_s:        "i32.load16_s",
+	Op_i32_load16_u:        "i32.load16_u",
+	Op_i64_load8_s:         "i64.load8_s",
+	Op_i64_load8_u:         "i64.load8_u",
+	Op_i64_load16_s:        "i64.load16_s",
+	Op_i64_load16_u:        "i64.load16_u",
+	Op_i64_load32_s:        "i64.load32_s",
+	Op_i64_load32_u:        "i64.load32_u",
+	Op_i32_store:           "i32.store",
+	Op_i64_store:           "i64.store",
+	Op_f32_store:           "f32.store",
+	Op_f64_store:           "f64.store",
+	Op_i32_store8:          "i32.store8",
+	Op_i32_store16:         "i32.store16",
+	Op_i64_store8:          "i64.store8",
+	Op_i64_store16:         "i64.store16",
+	Op_i64_store32:         "i64.store32",
+	Op_current_memory:      "memory.size",
+	Op_grow_memory:         "memory.grow",
+	Op_i32_const:           "i32.const",
+	Op_i64_const:           "i64.const",
+	Op_f32_const:           "f32.const",
+	Op_f64_const:           "f64.const",
+	Op_i32_eqz:             "i32.eqz",
+	Op_i32_eq:              "i32.eq",
+	Op_i32_ne:              "i32.ne",
+	Op_i32_lt_s:            "i32.lt_s",
+	Op_i32_lt_u:            "i32.lt_u",
+	Op_i32_gt_s:            "i32.gt_s",
+	Op_i32_gt_u:            "i32.gt_u",
+	Op_i32_le_s:            "i32.le_s",
+	Op_i32_le_u:            "i32.le_u",
+	Op_i32_ge_s:            "i32.ge_s",
+	Op_i32_ge_u:            "i32.ge_u",
+	Op_i64_eqz:             "i64.eqz",
+	Op_i64_eq:              "i64.eq",
+	Op_i64_ne:              "i64.ne",
+	Op_i64_lt_s:            "i64.lt_s",
+	Op_i64_lt_u:            "i64.lt_u",
+	Op_i64_gt_s:            "i64.gt_s",
+	Op_i64_gt_u:            "i64.gt_u",
+	Op_i64_le_s:            "i64.le_s",
+	Op_i64_le_u:            "i64.le_u",
+	Op_i64_ge_s:            "i64.ge_s",
+	Op_i64_ge_u:            "i64.ge_u",
+	Op_f32_eq:              "f32.eq",
+	Op_f32_ne:              "f32.ne",
+	Op_f32_lt:              "f32.lt",
+	Op_f32_gt:              "f32.gt",
+	Op_f32_le:              "f32.le",
+	Op_f32_ge:              "f32.ge",
+	Op_f64_eq:              "f64.eq",
+	Op_f64_ne:              "f64.ne",
+	Op_f64_lt:              "f64.lt",
+	Op_f64_gt:              "f64.gt",
+	Op_f64_le:              "f64.le",
+	Op_f64_ge:              "f64.ge",
+	Op_i32_clz:             "i32.clz",
+	Op_i32_ctz:             "i32.ctz",
+	Op_i32_popcnt:          "i32.popcnt",
+	Op_i32_add:             "i32.add",
+	Op_i32_sub:             "i32.sub",
+	Op_i32_mul:             "i32.mul",
+	Op_i32_div_s:           "i32.div_s",
+	Op_i32_div_u:           "i32.div_u",
+	Op_i32_rem_s:           "i32.rem_s",
+	Op_i32_rem_u:           "i32.rem_u",
+	Op_i32_and:             "i32.and",
+	Op_i32_or:              "i32.or",
+	Op_i32_xor:             "i32.xor",
+	Op_i32_shl:             "i32.shl",
+	Op_i32_shr_s:           "i32.shr_s",
+	Op_i32_shr_u:           "i32.shr_u",
+	Op_i32_rotl:            "i32.rotl",
+	Op_i32_rotr:            "i32.rotr",
+	Op_i64_clz:             "i64.clz",
+	Op_i64_ctz:             "i64.ctz",
+	Op_i64_popcnt:          "i64.popcnt",
+	Op_i64_add:             "i64.add",
+	Op_i64_sub:             "i64.sub",
+	Op_i64_mul:             "i64.mul",
+	Op_i64_div_s:           "i64.div_s",
+	Op_i64_div_u:           "i64.div_u",
+	Op_i64_rem_s:           "i64.rem_s",
+	Op_i64_rem_u:           "i64.rem_u",
+	Op_i64_and:             "i64.and",
+	Op_i64_or:              "i64.or",
+	Op_i64_xor:             "i64.xor",
+	Op_i64_shl:             "i64.shl",
+	Op_i64_shr_s:           "i64.shr_s",
+	Op_i64_shr_u:           "i64.shr_u",
+	Op_i64_rotl:            "i64.rotl",
+	Op_i64_rotr:            "i64.rotr",
+	Op_f32_abs:             "f32.abs",
+	Op_f32_neg:             "f32.neg",
+	Op_f32_ceil:            "f32.ceil",
+	Op_f32_floor:           "f32.floor",
+	Op_f32_trunc:           "f32.trunc",
+	Op_f32_nearest:         "f32.nearest",
+	Op_f32_sqrt:            "f32.sqrt",
+	Op_f32_add:             "f32.add",
+	Op_f32_sub:             "f32.sub",
+	Op_f32_mul:             "f32.mul",
+	Op_f32_div:             "f32.div",
+	Op_f32_min:             "f32.min",
+	Op_f32_max:             "f32.max",
+	Op_f32_copysign:        "f32.copysign",
+	Op_f64_abs:             "f64.abs",
+	Op_f64_neg:             "f64.neg",
+	Op_f64_ceil:            "f64.ceil",
+	Op_f64_floor:           "f64.floor",
+	Op_f64_trunc:           "f64.trunc",
+	Op_f64_nearest:         "f64.nearest",
+	Op_f64_sqrt:            "f64.sqrt",
+	Op_f64_add:             "f64.add",
+	Op_f64_sub:             "f64.sub",
+	Op_f64_mul:             "f64.mul",
+	Op_f64_div:             "f64.div",
+	Op_f64_min:             "f64.min",
+	Op_f64_max:             "f64.max",
+	Op_f64_copysign:        "f64.copysign",
+	Op_i32_wrap_i64:        "i32.wrap_i64",
+	Op_i32_trunc_s_f32:     "i32.trunc_f32_s",
+	Op_i32_trunc_u_f32:     "i32.trunc_f32_u",
+	Op_i32_trunc_s_f64:     "i32.trunc_f64_s",
+	Op_i32_trunc_u_f64:     "i32.trunc_f64_u",
+	Op_i64_extend_s_i32:    "i64.extend_i32_s",
+	Op_i64_extend_u_i32:    "i64.extend_i32_u",
+	Op_i64_trunc_s_f32:     "i64.trunc_f32_s",
+	Op_i64_trunc_u_f32:     "i64.trunc_f32_u",
+	Op_i64_trunc_s_f64:     "i64.trunc_f64_s",
+	Op_i64_trunc_u_f64:     "i64.trunc_f64_u",
+	Op_f32_convert_s_i32:   "f32.convert_i32_s",
+	Op_f32_convert_u_i32:   "f32.convert_i32_u",
+	Op_f32_convert_s_i64:   "f32.convert_i64_s",
+	Op_f32_convert_u_i64:   "f32.convert_i64_u",
+	Op_f32_demote_f64:      "f32.demote_f64",
+	Op_f64_convert_s_i32:   "f64.convert_i32_s",
+	Op_f64_convert_u_i32:   "f64.convert_i32_u",
+	Op_f64_convert_s_i64:   "f64.convert_i64_s",
+	Op_f64_convert_u_i64:   "f64.convert_i64_u",
+	Op_f64_promote_f32:     "f64.promote_f32",
+	Op_i32_reinterpret_f32: "i32.reinterpret_f32",
+	Op_i64_reinterpret_f64: "i64.reinterpret_f64",
+	Op_f32_reinterpret_i32: "f32.reinterpret_i32",
+	Op_f64_reinterpret_i64: "f64.reinterpret_i64",
+}
+
+// String returns the text format name of the opcode.
+func (o Opcode) String() string {
+	if name := opNames[o]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("opcode(0x%02x)", byte(o))
+}
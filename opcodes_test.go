@@ -0,0 +1,60 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import "testing"
+
+func TestOpcodeCategory(t *testing.T) {
+	const (
+		control = 1 << iota
+		parametric
+		variable
+		memory
+		numeric
+	)
+	tests := []struct {
+		op   Opcode
+		want int
+	}{
+		{Op_unreachable, control},
+		{Op_br_table, control},
+		{Op_call_indirect, control},
+		{Op_drop, parametric},
+		{Op_select, parametric},
+		{Op_get_local, variable},
+		{Op_set_global, variable},
+		{Op_i32_load, memory},
+		{Op_i64_store32, memory},
+		{Op_grow_memory, memory},
+		{Op_i32_const, numeric},
+		{Op_f64_ge, numeric},
+		{Op_i32_add, numeric},
+		{Op_f64_reinterpret_i64, numeric},
+		{Op_try, control},
+		{Op_catch_all, control},
+		{0x12, 0},
+		{Op_simd_prefix, 0},
+	}
+	for _, tt := range tests {
+		got := 0
+		for _, c := range []struct {
+			bit int
+			is  func() bool
+		}{
+			{control, tt.op.IsControl},
+			{parametric, tt.op.IsParametric},
+			{variable, tt.op.IsVariable},
+			{memory, tt.op.IsMemory},
+			{numeric, tt.op.IsNumeric},
+		} {
+			if c.is() {
+				got |= c.bit
+			}
+		}
+		if got != tt.want {
+			t.Errorf("opcode 0x%02x: got categories %05b, want %05b", byte(tt.op), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+	.functype	ext (i32) -> (i32)
+	.text
+	.globl	add
+	.type	add,@function
+add:
+	.functype	add (i32, i32) -> (i32)
+	local.get	0
+	local.get	1
+	i32.add
+	call	ext
+	i32.const	0
+	i32.load	counter
+	i32.add
+	end_function
+
+	.hidden	main
+	.globl	main
+	.type	main,@function
+main:
+	.functype	main () -> (i32)
+	i32.const	1
+	i32.const	2
+	call	add
+	end_function
+
+	.type	counter,@object
+	.section	.data.counter,"",@
+	.globl	counter
+	.p2align	2
+counter:
+	.int32	42
+	.size	counter, 4
+
+	.type	ptr,@object
+	.section	.data.ptr,"",@
+	.globl	ptr
+	.p2align	2
+ptr:
+	.int32	counter+4
+	.size	ptr, 4
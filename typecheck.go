@@ -0,0 +1,684 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errTypeMismatch = errors.New("wasm: type mismatch")
+	errUnderflow    = errors.New("wasm: operand stack underflow")
+	errUnbalanced   = errors.New("wasm: unbalanced control frames")
+	errBadAlign     = errors.New("wasm: alignment larger than natural")
+	errBadIndex     = errors.New("wasm: index out of range")
+	errBadBlockType = errors.New("wasm: invalid block type")
+	errNoMemory     = errors.New("wasm: no memory declared")
+	errNoTable      = errors.New("wasm: no table declared")
+	errImmutable    = errors.New("wasm: global is immutable")
+	errFuncCount    = errors.New("wasm: function and code section mismatch")
+)
+
+// valUnknown is the type of an operand popped from an unreachable stack.
+const valUnknown ValueType = 0
+
+// CodeError reports an error found in the code of a function.
+type CodeError struct {
+	Func   uint32 // absolute function index
+	Offset int    // offset of the instruction within FunctionBody.Code
+	Err    error
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("wasm: func %d offset %d: %v", e.Func, e.Offset, e.Err)
+}
+
+// moduleContext holds the index spaces referenced by function bodies.
+type moduleContext struct {
+	types   []FuncType
+	funcs   []uint32 // type index of each function
+	globals []GlobalType
+	tables  int
+	mems    int
+
+	importedGlobals int // the imported globals come first in globals
+}
+
+func (m Module) context() moduleContext {
+	var mc moduleContext
+	if s, ok := m.section(TypeID).(TypeSection); ok {
+		mc.types = s.Types
+	}
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			switch typ := imp.Typ.(type) {
+			case uint32:
+				mc.funcs = append(mc.funcs, typ)
+			case GlobalType:
+				mc.globals = append(mc.globals, typ)
+			case TableType:
+				mc.tables++
+			case MemoryType:
+				mc.mems++
+			}
+		}
+	}
+	mc.importedGlobals = len(mc.globals)
+	if s, ok := m.section(FunctionID).(FunctionSection); ok {
+		mc.funcs = append(mc.funcs, s.Types...)
+	}
+	if s, ok := m.section(TableID).(TableSection); ok {
+		mc.tables += len(s.tables)
+	}
+	if s, ok := m.section(MemoryID).(MemorySection); ok {
+		mc.mems += len(s.memories)
+	}
+	if s, ok := m.section(GlobalID).(GlobalSection); ok {
+		for _, gv := range s.globals {
+			mc.globals = append(mc.globals, gv.Type)
+		}
+	}
+	return mc
+}
+
+// funcType returns the signature of function idx, or nil.
+func (mc *moduleContext) funcType(idx uint32) *FuncType {
+	if int64(idx) >= int64(len(mc.funcs)) {
+		return nil
+	}
+	return mc.typeAt(mc.funcs[idx])
+}
+
+// typeAt returns the type section entry idx, or nil.
+func (mc *moduleContext) typeAt(idx uint32) *FuncType {
+	if int64(idx) >= int64(len(mc.types)) {
+		return nil
+	}
+	return &mc.types[idx]
+}
+
+// TypeCheck validates the code of every function body, checking the
+// instructions operand types against the function signatures.
+func (m Module) TypeCheck() error {
+	mc := m.context()
+	code, _ := m.section(CodeID).(CodeSection)
+	nImp := m.NumImportedFuncs()
+	if len(mc.funcs)-nImp != len(code.Bodies) {
+		return errFuncCount
+	}
+	for i := range code.Bodies {
+		idx := uint32(nImp + i)
+		if err := mc.checkFunc(idx, &code.Bodies[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ctrlFrame struct {
+	op          Opcode
+	params      []ValueType
+	results     []ValueType
+	height      int
+	unreachable bool
+}
+
+// labelTypes returns the operand types expected by a branch to the frame.
+func (f *ctrlFrame) labelTypes() []ValueType {
+	if f.op == Op_loop {
+		return f.params
+	}
+	return f.results
+}
+
+type funcChecker struct {
+	mc     *moduleContext
+	sig    *FuncType
+	locals []LocalEntry // parameters followed by declared locals
+	vals   []ValueType
+	ctrls  []ctrlFrame
+}
+
+func (mc *moduleContext) checkFunc(idx uint32, fb *FunctionBody) error {
+	sig := mc.funcType(idx)
+	if sig == nil {
+		return &CodeError{Func: idx, Err: errBadIndex}
+	}
+	insns, err := fb.decode(idx)
+	if err != nil {
+		return err
+	}
+
+	fc := funcChecker{mc: mc, sig: sig}
+	for _, vt := range sig.params {
+		fc.locals = append(fc.locals, LocalEntry{Count: 1, Type: vt})
+	}
+	fc.locals = append(fc.locals, fb.Locals...)
+	fc.pushCtrl(Op_block, nil, sig.results)
+	for i := range insns {
+		if len(fc.ctrls) == 0 {
+			return &CodeError{Func: idx, Offset: insns[i].Offset, Err: errUnbalanced}
+		}
+		if err := fc.check(&insns[i]); err != nil {
+			return &CodeError{Func: idx, Offset: insns[i].Offset, Err: err}
+		}
+	}
+	if len(fc.ctrls) != 0 {
+		// the last instruction should have closed the function body
+		var off int
+		if len(insns) > 0 {
+			off = insns[len(insns)-1].Offset
+		}
+		return &CodeError{Func: idx, Offset: off, Err: errUnbalanced}
+	}
+	return nil
+}
+
+func (fc *funcChecker) push(vt ValueType) {
+	fc.vals = append(fc.vals, vt)
+}
+
+func (fc *funcChecker) pushVals(vts []ValueType) {
+	fc.vals = append(fc.vals, vts...)
+}
+
+func (fc *funcChecker) pop() (ValueType, error) {
+	f := &fc.ctrls[len(fc.ctrls)-1]
+	if len(fc.vals) == f.height {
+		if f.unreachable {
+			return valUnknown, nil
+		}
+		return valUnknown, errUnderflow
+	}
+	vt := fc.vals[len(fc.vals)-1]
+	fc.vals = fc.vals[:len(fc.vals)-1]
+	return vt, nil
+}
+
+func (fc *funcChecker) popExpect(want ValueType) error {
+	vt, err := fc.pop()
+	if err != nil {
+		return err
+	}
+	if vt != want && vt != valUnknown && want != valUnknown {
+		return errTypeMismatch
+	}
+	return nil
+}
+
+func (fc *funcChecker) popVals(vts []ValueType) error {
+	for i := len(vts) - 1; i >= 0; i-- {
+		if err := fc.popExpect(vts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fc *funcChecker) pushCtrl(op Opcode, params, results []ValueType) {
+	fc.ctrls = append(fc.ctrls, ctrlFrame{
+		op:      op,
+		params:  params,
+		results: results,
+		height:  len(fc.vals),
+	})
+	fc.pushVals(params)
+}
+
+func (fc *funcChecker) popCtrl() (ctrlFrame, error) {
+	f := fc.ctrls[len(fc.ctrls)-1]
+	if err := fc.popVals(f.results); err != nil {
+		return f, err
+	}
+	if len(fc.vals) != f.height {
+		return f, errTypeMismatch
+	}
+	fc.ctrls = fc.ctrls[:len(fc.ctrls)-1]
+	return f, nil
+}
+
+func (fc *funcChecker) setUnreachable() {
+	f := &fc.ctrls[len(fc.ctrls)-1]
+	fc.vals = fc.vals[:f.height]
+	f.unreachable = true
+}
+
+func (fc *funcChecker) label(depth uint32) (*ctrlFrame, error) {
+	if int64(depth) >= int64(len(fc.ctrls)) {
+		return nil, errBadIndex
+	}
+	return &fc.ctrls[len(fc.ctrls)-1-int(depth)], nil
+}
+
+func (fc *funcChecker) localType(idx uint32) (ValueType, error) {
+	n := uint64(idx)
+	for _, le := range fc.locals {
+		if n < uint64(le.Count) {
+			return le.Type, nil
+		}
+		n -= uint64(le.Count)
+	}
+	return valUnknown, errBadIndex
+}
+
+// blockType returns the signature of a block, loop or if, a non-negative
+// block type is the index of a function type of the multi-value proposal.
+func (fc *funcChecker) blockType(bt BlockType) ([]ValueType, []ValueType, error) {
+	if bt >= 0 {
+		ft := fc.mc.typeAt(uint32(bt))
+		if ft == nil {
+			return nil, nil, errBadIndex
+		}
+		if ft.form != ValueFunc {
+			return nil, nil, errBadBlockType
+		}
+		return ft.params, ft.results, nil
+	}
+	switch vt := ValueType(bt); vt {
+	case ValueBlock:
+		return nil, nil, nil
+	case ValueI32, ValueI64, ValueF32, ValueF64, ValueV128:
+		return nil, []ValueType{vt}, nil
+	}
+	return nil, nil, errBadBlockType
+}
+
+// memOp describes the natural alignment and value type of a load or store.
+type memOp struct {
+	align uint32
+	typ   ValueType
+}
+
+var memOps = map[Opcode]memOp{
+	Op_i32_load:     {2, ValueI32},
+	Op_i64_load:     {3, ValueI64},
+	Op_f32_load:     {2, ValueF32},
+	Op_f64_load:     {3, ValueF64},
+	Op_i32_load8_s:  {0, ValueI32},
+	Op_i32_load8_u:  {0, ValueI32},
+	Op_i32_load16_s: {1, ValueI32},
+	Op_i32_load16_u: {1, ValueI32},
+	Op_i64_load8_s:  {0, ValueI64},
+	Op_i64_load8_u:  {0, ValueI64},
+	Op_i64_load16_s: {1, ValueI64},
+	Op_i64_load16_u: {1, ValueI64},
+	Op_i64_load32_s: {2, ValueI64},
+	Op_i64_load32_u: {2, ValueI64},
+	Op_i32_store:    {2, ValueI32},
+	Op_i64_store:    {3, ValueI64},
+	Op_f32_store:    {2, ValueF32},
+	Op_f64_store:    {3, ValueF64},
+	Op_i32_store8:   {0, ValueI32},
+	Op_i32_store16:  {1, ValueI32},
+	Op_i64_store8:   {0, ValueI64},
+	Op_i64_store16:  {1, ValueI64},
+	Op_i64_store32:  {2, ValueI64},
+}
+
+// numSig returns the operand and result types of a numeric, comparison or
+// conversion operator.
+func numSig(op Opcode) (params []ValueType, result ValueType) {
+	unop := func(t ValueType) []ValueType { return []ValueType{t} }
+	binop := func(t ValueType) []ValueType { return []ValueType{t, t} }
+	switch {
+	case op < Op_i32_eqz:
+		return nil, valUnknown
+	case op == Op_i32_eqz:
+		return unop(ValueI32), ValueI32
+	case op <= Op_i32_ge_u:
+		return binop(ValueI32), ValueI32
+	case op == Op_i64_eqz:
+		return unop(ValueI64), ValueI32
+	case op <= Op_i64_ge_u:
+		return binop(ValueI64), ValueI32
+	case op <= Op_f32_ge:
+		return binop(ValueF32), ValueI32
+	case op <= Op_f64_ge:
+		return binop(ValueF64), ValueI32
+	case op <= Op_i32_popcnt:
+		return unop(ValueI32), ValueI32
+	case op <= Op_i32_rotr:
+		return binop(ValueI32), ValueI32
+	case op <= Op_i64_popcnt:
+		return unop(ValueI64), ValueI64
+	case op <= Op_i64_rotr:
+		return binop(ValueI64), ValueI64
+	case op <= Op_f32_sqrt:
+		return unop(ValueF32), ValueF32
+	case op <= Op_f32_copysign:
+		return binop(ValueF32), ValueF32
+	case op <= Op_f64_sqrt:
+		return unop(ValueF64), ValueF64
+	case op <= Op_f64_copysign:
+		return binop(ValueF64), ValueF64
+	}
+
+	switch op {
+	case Op_i32_wrap_i64:
+		return unop(ValueI64), ValueI32
+	case Op_i32_trunc_s_f32, Op_i32_trunc_u_f32, Op_i32_reinterpret_f32:
+		return unop(ValueF32), ValueI32
+	case Op_i32_trunc_s_f64, Op_i32_trunc_u_f64:
+		return unop(ValueF64), ValueI32
+	case Op_i64_extend_s_i32, Op_i64_extend_u_i32:
+		return unop(ValueI32), ValueI64
+	case Op_i64_trunc_s_f32, Op_i64_trunc_u_f32:
+		return unop(ValueF32), ValueI64
+	case Op_i64_trunc_s_f64, Op_i64_trunc_u_f64, Op_i64_reinterpret_f64:
+		return unop(ValueF64), ValueI64
+	case Op_f32_convert_s_i32, Op_f32_convert_u_i32, Op_f32_reinterpret_i32:
+		return unop(ValueI32), ValueF32
+	case Op_f32_convert_s_i64, Op_f32_convert_u_i64:
+		return unop(ValueI64), ValueF32
+	case Op_f32_demote_f64:
+		return unop(ValueF64), ValueF32
+	case Op_f64_convert_s_i32, Op_f64_convert_u_i32:
+		return unop(ValueI32), ValueF64
+	case Op_f64_convert_s_i64, Op_f64_convert_u_i64, Op_f64_reinterpret_i64:
+		return unop(ValueI64), ValueF64
+	case Op_f64_promote_f32:
+		return unop(ValueF32), ValueF64
+	}
+	return nil, valUnknown
+}
+
+// UsesFloatingPoint reports whether the module uses f32 or f64 values: in a
+// function signature, a global, a local, a block type or an instruction.
+// It fails if the code was skipped or does not decode.
+func (m Module) UsesFloatingPoint() (bool, error) {
+	mc := m.context()
+	for i := range mc.types {
+		if hasFloat(mc.types[i].params) || hasFloat(mc.types[i].results) {
+			return true, nil
+		}
+	}
+	for _, gt := range mc.globals {
+		if isFloat(gt.ContentType) {
+			return true, nil
+		}
+	}
+	code, _ := m.section(CodeID).(CodeSection)
+	if code.Skipped != 0 {
+		return false, errSkippedCode
+	}
+	for i, fb := range code.Bodies {
+		for _, le := range fb.Locals {
+			if isFloat(le.Type) {
+				return true, nil
+			}
+		}
+		insns, err := fb.decode(m.AbsoluteFuncIndex(i))
+		if err != nil {
+			return false, err
+		}
+		for _, ins := range insns {
+			switch op := ins.Op; {
+			case op == Op_f32_const || op == Op_f64_const:
+				return true, nil
+			case opImmediate(op) == immBlock && ins.Block < 0 && isFloat(ValueType(ins.Block)):
+				return true, nil
+			case opImmediate(op) == immMemArg && isFloat(memOps[op].typ):
+				return true, nil
+			}
+			if params, result := numSig(ins.Op); hasFloat(params) || isFloat(result) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func isFloat(vt ValueType) bool {
+	return vt == ValueF32 || vt == ValueF64
+}
+
+func hasFloat(vts []ValueType) bool {
+	for _, vt := range vts {
+		if isFloat(vt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fc *funcChecker) check(ins *Instruction) error {
+	switch op := ins.Op; op {
+	case Op_unreachable:
+		fc.setUnreachable()
+
+	case Op_nop:
+
+	case Op_block, Op_loop, Op_if:
+		params, results, err := fc.blockType(ins.Block)
+		if err != nil {
+			return err
+		}
+		if op == Op_if {
+			if err := fc.popExpect(ValueI32); err != nil {
+				return err
+			}
+		}
+		if err := fc.popVals(params); err != nil {
+			return err
+		}
+		fc.pushCtrl(op, params, results)
+
+	case Op_else:
+		f, err := fc.popCtrl()
+		if err != nil {
+			return err
+		}
+		if f.op != Op_if {
+			return errUnbalanced
+		}
+		fc.pushCtrl(Op_else, f.params, f.results)
+
+	case Op_end:
+		f, err := fc.popCtrl()
+		if err != nil {
+			return err
+		}
+		if f.op == Op_if && !eqValues(f.params, f.results) {
+			// an if without else must leave its operands unchanged
+			return errTypeMismatch
+		}
+		fc.pushVals(f.results)
+
+	case Op_br:
+		f, err := fc.label(ins.Index)
+		if err != nil {
+			return err
+		}
+		if err := fc.popVals(f.labelTypes()); err != nil {
+			return err
+		}
+		fc.setUnreachable()
+
+	case Op_br_if:
+		if err := fc.popExpect(ValueI32); err != nil {
+			return err
+		}
+		f, err := fc.label(ins.Index)
+		if err != nil {
+			return err
+		}
+		if err := fc.popVals(f.labelTypes()); err != nil {
+			return err
+		}
+		fc.pushVals(f.labelTypes())
+
+	case Op_br_table:
+		if err := fc.popExpect(ValueI32); err != nil {
+			return err
+		}
+		def, err := fc.label(ins.Index)
+		if err != nil {
+			return err
+		}
+		arity := len(def.labelTypes())
+		for _, depth := range ins.Targets {
+			f, err := fc.label(depth)
+			if err != nil {
+				return err
+			}
+			if len(f.labelTypes()) != arity {
+				return errTypeMismatch
+			}
+			// check the operands without consuming them
+			height := len(fc.vals)
+			saved := append([]ValueType(nil), fc.vals...)
+			if err := fc.popVals(f.labelTypes()); err != nil {
+				return err
+			}
+			fc.vals = append(fc.vals[:0], saved[:height]...)
+		}
+		if err := fc.popVals(def.labelTypes()); err != nil {
+			return err
+		}
+		fc.setUnreachable()
+
+	case Op_return:
+		if err := fc.popVals(fc.sig.results); err != nil {
+			return err
+		}
+		fc.setUnreachable()
+
+	case Op_call:
+		ft := fc.mc.funcType(ins.Index)
+		if ft == nil {
+			return errBadIndex
+		}
+		if err := fc.popVals(ft.params); err != nil {
+			return err
+		}
+		fc.pushVals(ft.results)
+
+	case Op_call_indirect:
+		if int64(ins.Table) >= int64(fc.mc.tables) {
+			return errNoTable
+		}
+		ft := fc.mc.typeAt(ins.Index)
+		if ft == nil {
+			return errBadIndex
+		}
+		if err := fc.popExpect(ValueI32); err != nil {
+			return err
+		}
+		if err := fc.popVals(ft.params); err != nil {
+			return err
+		}
+		fc.pushVals(ft.results)
+
+	case Op_drop:
+		if _, err := fc.pop(); err != nil {
+			return err
+		}
+
+	case Op_select:
+		if err := fc.popExpect(ValueI32); err != nil {
+			return err
+		}
+		t1, err := fc.pop()
+		if err != nil {
+			return err
+		}
+		t2, err := fc.pop()
+		if err != nil {
+			return err
+		}
+		if t1 != t2 && t1 != valUnknown && t2 != valUnknown {
+			return errTypeMismatch
+		}
+		if t1 == valUnknown {
+			t1 = t2
+		}
+		fc.push(t1)
+
+	case Op_get_local, Op_set_local, Op_tee_local:
+		vt, err := fc.localType(ins.Index)
+		if err != nil {
+			return err
+		}
+		if op != Op_get_local {
+			if err := fc.popExpect(vt); err != nil {
+				return err
+			}
+		}
+		if op != Op_set_local {
+			fc.push(vt)
+		}
+
+	case Op_get_global, Op_set_global:
+		if int64(ins.Index) >= int64(len(fc.mc.globals)) {
+			return errBadIndex
+		}
+		gt := fc.mc.globals[ins.Index]
+		if op == Op_get_global {
+			fc.push(gt.ContentType)
+			break
+		}
+		if gt.Mutability == 0 {
+			return errImmutable
+		}
+		if err := fc.popExpect(gt.ContentType); err != nil {
+			return err
+		}
+
+	case Op_current_memory, Op_grow_memory:
+		if fc.mc.mems == 0 {
+			return errNoMemory
+		}
+		if op == Op_grow_memory {
+			if err := fc.popExpect(ValueI32); err != nil {
+				return err
+			}
+		}
+		fc.push(ValueI32)
+
+	case Op_i32_const:
+		fc.push(ValueI32)
+	case Op_i64_const:
+		fc.push(ValueI64)
+	case Op_f32_const:
+		fc.push(ValueF32)
+	case Op_f64_const:
+		fc.push(ValueF64)
+	case Op_simd_prefix:
+		// only v128.const is decoded
+		fc.push(ValueV128)
+
+	default:
+		if mo, ok := memOps[op]; ok {
+			if fc.mc.mems == 0 {
+				return errNoMemory
+			}
+			if ins.Mem.Align > mo.align {
+				return errBadAlign
+			}
+			if op >= Op_i32_store {
+				if err := fc.popExpect(mo.typ); err != nil {
+					return err
+				}
+				return fc.popExpect(ValueI32)
+			}
+			if err := fc.popExpect(ValueI32); err != nil {
+				return err
+			}
+			fc.push(mo.typ)
+			break
+		}
+
+		params, result := numSig(op)
+		if params == nil {
+			return errInvOp
+		}
+		if err := fc.popVals(params); err != nil {
+			return err
+		}
+		fc.push(result)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"testing"
+)
+
+// codeModule returns a module with a memory and a single "(func)" with code.
+func codeModule(code ...byte) Module {
+	return Module{
+		Header: ModuleHeader{Magic: magicWASM, Version: 1},
+		Sections: []Section{
+			TypeSection{Types: []FuncType{{form: ValueFunc}}},
+			FunctionSection{Types: []uint32{0}},
+			MemorySection{memories: []MemoryType{{Limits: ResizableLimits{Initial: 1}}}},
+			CodeSection{Bodies: []FunctionBody{{Code: code}}},
+		},
+	}
+}
+
+func TestMemArg(t *testing.T) {
+	// i32.const 0; i32.load offset=4 align=2; drop; end
+	code := []byte{0x41, 0x00, 0x28, 0x02, 0x04, 0x1a, 0x0b}
+	insns, err := FunctionBody{Code: code}.Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insns[1].Op != Op_i32_load {
+		t.Fatalf("got op 0x%x, want i32.load", byte(insns[1].Op))
+	}
+	if want := (MemArg{Align: 2, Offset: 4}); insns[1].Mem != want {
+		t.Errorf("MemArg = %+v, want %+v", insns[1].Mem, want)
+	}
+	if err := codeModule(code...).TypeCheck(); err != nil {
+		t.Errorf("TypeCheck: %v", err)
+	}
+
+	// align=3 exceeds the natural alignment of i32.load
+	code[3] = 0x03
+	err = codeModule(code...).TypeCheck()
+	if ce, ok := err.(*CodeError); !ok || ce.Err != errBadAlign || ce.Offset != 2 {
+		t.Errorf("TypeCheck: got %v, want %v at offset 2", err, errBadAlign)
+	}
+}
+
+func TestCodeErrorOffset(t *testing.T) {
+	// nop; nop; <invalid opcode>; end
+	mod := codeModule(0x01, 0x01, 0x12, 0x0b)
+	_, histErr := mod.OpcodeHistogram()
+	_, disErr := mod.DisassembleFunc(0, DisassembleOptions{})
+	for _, err := range []error{mod.TypeCheck(), histErr, disErr} {
+		if ce, ok := err.(*CodeError); !ok || ce.Offset != 2 {
+			t.Errorf("got %v, want a code error at offset 2", err)
+		}
+	}
+
+	// block; end; the function body is not closed
+	err := codeModule(0x02, 0x40, 0x0b).TypeCheck()
+	if ce, ok := err.(*CodeError); !ok || ce.Err != errUnbalanced || ce.Offset != 2 {
+		t.Errorf("TypeCheck: got %v, want %v at offset 2", err, errUnbalanced)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.TypeCheck(); err != nil {
+		t.Errorf("TypeCheck(hello.wasm): %v", err)
+	}
+
+	tests := []struct {
+		code []byte
+		err  error
+	}{
+		// block (result i32) i32.const 1 end; drop; end
+		{[]byte{0x02, 0x7f, 0x41, 0x01, 0x0b, 0x1a, 0x0b}, nil},
+		// i64.const 0; i32.const 1; i32.add; drop; end
+		{[]byte{0x42, 0x00, 0x41, 0x01, 0x6a, 0x1a, 0x0b}, errTypeMismatch},
+		// i32.const 1; end
+		{[]byte{0x41, 0x01, 0x0b}, errTypeMismatch},
+		// drop; end
+		{[]byte{0x1a, 0x0b}, errUnderflow},
+		// unreachable; drop; i32.const 0; br_if 0; end
+		{[]byte{0x00, 0x1a, 0x41, 0x00, 0x0d, 0x00, 0x0b}, nil},
+		// loop; br 0; end; end
+		{[]byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b}, nil},
+		// block; br 2; end; end
+		{[]byte{0x02, 0x40, 0x0c, 0x02, 0x0b, 0x0b}, errBadIndex},
+		// i32.const 0; if (result i32) i32.const 1 end; drop; end
+		{[]byte{0x41, 0x00, 0x04, 0x7f, 0x41, 0x01, 0x0b, 0x1a, 0x0b}, errTypeMismatch},
+		// i32.const 0; if (result i32) i32.const 1 else i32.const 2 end; drop; end
+		{[]byte{0x41, 0x00, 0x04, 0x7f, 0x41, 0x01, 0x05, 0x41, 0x02, 0x0b, 0x1a, 0x0b}, nil},
+		// get_global 0; end
+		{[]byte{0x23, 0x00, 0x0b}, errBadIndex},
+		// block; end
+		{[]byte{0x02, 0x40, 0x0b}, errUnbalanced},
+		// end; nop
+		{[]byte{0x0b, 0x01}, errUnbalanced},
+	}
+	for _, tt := range tests {
+		err := codeModule(tt.code...).TypeCheck()
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("TypeCheck(%x): %v", tt.code, err)
+			}
+			continue
+		}
+		if ce, ok := err.(*CodeError); !ok || ce.Err != tt.err {
+			t.Errorf("TypeCheck(%x): got %v, want %v", tt.code, err, tt.err)
+		}
+	}
+}
+
+func TestMultiValueBlock(t *testing.T) {
+	i32 := ValueI32
+	tests := []struct {
+		code []byte
+		err  error
+	}{
+		// i32.const 1; i32.const 2; block (type 1) i32.add i32.const 3 end;
+		// i32.add; drop; end
+		{[]byte{0x41, 0x01, 0x41, 0x02, 0x02, 0x01, 0x6a, 0x41, 0x03, 0x0b, 0x6a, 0x1a, 0x0b}, nil},
+		// i32.const 1; loop (type 1) ... missing the second parameter
+		{[]byte{0x41, 0x01, 0x03, 0x01, 0x0b, 0x1a, 0x1a, 0x0b}, errUnderflow},
+		// i32.const 1; i32.const 2; block (type 1) i32.add end; drop; end
+		{[]byte{0x41, 0x01, 0x41, 0x02, 0x02, 0x01, 0x6a, 0x0b, 0x1a, 0x0b}, errUnderflow},
+		// block (type 2) end; end
+		{[]byte{0x02, 0x02, 0x0b, 0x0b}, errBadIndex},
+	}
+	for _, tt := range tests {
+		mod := codeModule(tt.code...)
+		mod.Sections[0] = TypeSection{Types: []FuncType{
+			{form: ValueFunc},
+			{form: ValueFunc, params: []ValueType{i32, i32}, results: []ValueType{i32, i32}},
+		}}
+		err := mod.TypeCheck()
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("TypeCheck(%x): %v", tt.code, err)
+			}
+			continue
+		}
+		if ce, ok := err.(*CodeError); !ok || ce.Err != tt.err {
+			t.Errorf("TypeCheck(%x): got %v, want %v", tt.code, err, tt.err)
+		}
+	}
+
+	ins := Instruction{Op: Op_block, Block: 1}
+	if got := ins.String(); got != "block (type 1)" {
+		t.Errorf("got %q, want %q", got, "block (type 1)")
+	}
+}
+
+func TestUsesFloatingPoint(t *testing.T) {
+	f64 := func(v byte) []byte { return []byte{0x44, 0, 0, 0, 0, 0, 0, 0xf0, v} }
+	tests := []struct {
+		mod  Module
+		want bool
+	}{
+		// i32.const 1; i32.const 2; i32.add; drop; end
+		{codeModule(0x41, 0x01, 0x41, 0x02, 0x6a, 0x1a, 0x0b), false},
+		// f64.const 1; f64.const 2; f64.add; drop; end
+		{codeModule(append(append(f64(0x3f), f64(0x40)...), 0xa0, 0x1a, 0x0b)...), true},
+		// i32.const 0; f32.load; drop; end
+		{codeModule(0x41, 0x00, 0x2a, 0x02, 0x00, 0x1a, 0x0b), true},
+		// block (result f32) unreachable end; drop; end
+		{codeModule(0x02, 0x7d, 0x00, 0x0b, 0x1a, 0x0b), true},
+	}
+	for i, tt := range tests {
+		got, err := tt.mod.UsesFloatingPoint()
+		if err != nil || got != tt.want {
+			t.Errorf("%d: got %v, %v, want %v", i, got, err, tt.want)
+		}
+	}
+
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := mod.UsesFloatingPoint(); err != nil || got {
+		t.Errorf("hello.wasm: got %v, %v, want false", got, err)
+	}
+	mod = codeModule(0x0b)
+	mod.Sections[0] = TypeSection{Types: []FuncType{NewFuncType([]ValueType{ValueF64}, nil)}}
+	if got, _ := mod.UsesFloatingPoint(); !got {
+		t.Errorf("f64 parameter: got false, want true")
+	}
+}
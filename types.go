@@ -5,9 +5,12 @@
 package wasm
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 )
 
 var order = binary.LittleEndian
@@ -17,6 +20,12 @@ var (
 	errInvOp    = errors.New("wasm: invalid Op code")
 	errOpEnd    = errors.New("wasm: must be Op_End")
 	errMalform  = errors.New("wasm: varint/varuint malformed")
+
+	errNonCanonical = errors.New("wasm: non-canonical LEB128 encoding")
+	errLocals       = errors.New("wasm: too many locals in function body")
+	errTrailing     = errors.New("wasm: trailing bytes")
+	errBrTable      = errors.New("wasm: br_table label count exceeds the code size")
+	errLength       = errors.New("wasm: length exceeds the bytes left in the section")
 )
 
 type (
@@ -77,17 +86,54 @@ func (vp *varuint32) bytes() []byte {
 	return ret[:i]
 }
 
+func (vp *varint64) bytes() []byte {
+	v := int64(*vp)
+	var ret []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(ret, b)
+		}
+		ret = append(ret, b|0x80)
+	}
+}
+
+// readByte reads a byte from r, the readers used by the decoder are read
+// without allocating.
+func readByte(r io.Reader) (byte, error) {
+	switch r := r.(type) {
+	case io.ByteReader:
+		return r.ReadByte()
+	case *io.LimitedReader:
+		if br, ok := r.R.(io.ByteReader); ok {
+			if r.N <= 0 {
+				return 0, io.EOF
+			}
+			b, err := br.ReadByte()
+			if err == nil {
+				r.N--
+			}
+			return b, err
+		}
+	}
+	var buf [1]byte
+	_, err := io.ReadFull(r, buf[:])
+	return buf[0], err
+}
+
 // uvarint for uvar1/uvar7/uvar32, no uvar64
 func uvarint(r io.Reader) (uint32, int, error) {
 	var x uint32
 	var s uint
-	var buf = make([]byte, 1)
 	for i := 0; ; i++ {
-		_, err := r.Read(buf)
+		b, err := readByte(r)
+		if err == io.EOF && i > 0 {
+			err = io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return 0, i, err
 		}
-		b := buf[0]
 		if b < 0x80 {
 			if i > 4 || i == 4 && b > 15 {
 				return 0, i, errOverflow
@@ -97,20 +143,43 @@ func uvarint(r io.Reader) (uint32, int, error) {
 		x |= uint32(b&0x7f) << s
 		s += 7
 	}
-	panic("unreachable")
+}
+
+// uvarint64 for the u64 limits of memory64
+func uvarint64(r io.Reader) (uint64, int, error) {
+	var x uint64
+	var s uint
+	for i := 0; ; i++ {
+		b, err := readByte(r)
+		if err == io.EOF && i > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, i, err
+		}
+		if b < 0x80 {
+			if i > 9 || i == 9 && b > 1 {
+				return 0, i, errOverflow
+			}
+			return x | uint64(b)<<s, i + 1, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
 }
 
 // varint for var7/var32/var64
 func varint(r io.Reader) (int64, int, error) {
 	var x int64
 	var s uint
-	var buf = make([]byte, 1)
 	for i := 0; ; i++ {
-		_, err := r.Read(buf)
+		b, err := readByte(r)
+		if err == io.EOF && i > 0 {
+			err = io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return 0, i, err
 		}
-		b := buf[0]
 		if b < 0x80 {
 			if i > 9 || i == 9 && b > 1 {
 				return 0, i, errOverflow
@@ -123,7 +192,26 @@ func varint(r io.Reader) (int64, int, error) {
 		x |= int64(b&0x7f) << s
 		s += 7
 	}
-	panic("unreachable")
+}
+
+// uvarintLen returns the length of the minimal LEB128 encoding of v.
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// varintLen returns the length of the minimal signed LEB128 encoding of v.
+func varintLen(v int64) int {
+	n := 1
+	for v < -64 || v >= 64 {
+		v >>= 7
+		n++
+	}
+	return n
 }
 
 type ValueType int8
@@ -132,7 +220,9 @@ type ValueType int8
 // 0x7e: i64
 // 0x7d: f32
 // 0x7c: f64
-// 0x70: anyfunc
+// 0x7b: v128
+// 0x70: anyfunc (funcref)
+// 0x6f: externref
 // 0x60: func
 // 0x40: pseudo type for an empty block_type
 const (
@@ -140,11 +230,27 @@ const (
 	ValueI64               = -0x02
 	ValueF32               = -0x03
 	ValueF64               = -0x04
+	ValueV128              = -0x05
 	ValueAnyFunc           = -0x10
 	ValueFunc              = -0x20
 	ValueBlock             = -0x40
 )
 
+// reference types of the reference-types proposal
+const (
+	ValueFuncRef   ValueType = ValueAnyFunc // 0x70
+	ValueExternRef ValueType = -0x11        // 0x6f
+)
+
+// type forms of the GC proposal, their types are decoded opaquely
+const (
+	ValueStruct   ValueType = -0x21 // 0x5f
+	ValueArray    ValueType = -0x22 // 0x5e
+	ValueSub      ValueType = -0x30 // 0x50
+	ValueSubFinal ValueType = -0x31 // 0x4f
+	ValueRec      ValueType = -0x32 // 0x4e
+)
+
 func (v ValueType) String() string {
 	switch v {
 	case ValueI32:
@@ -155,12 +261,26 @@ func (v ValueType) String() string {
 		return "f32"
 	case ValueF64:
 		return "f64"
-	case ValueAnyFunc:
-		return "anyfunc"
+	case ValueV128:
+		return "v128"
+	case ValueFuncRef:
+		return "funcref"
+	case ValueExternRef:
+		return "externref"
 	case ValueFunc:
 		return "func"
 	case ValueBlock:
 		return "block_type"
+	case ValueStruct:
+		return "struct"
+	case ValueArray:
+		return "array"
+	case ValueSub:
+		return "sub"
+	case ValueSubFinal:
+		return "sub final"
+	case ValueRec:
+		return "rec"
 	}
 	return "unknown"
 }
@@ -168,10 +288,52 @@ func (v ValueType) String() string {
 type BlockType varint7
 type ElemType varint7
 
+// 0x70: funcref (anyfunc)
+// 0x6f: externref
+const (
+	ElemFuncRef   ElemType = -0x10
+	ElemExternRef ElemType = -0x11
+)
+
+func (et ElemType) String() string {
+	switch et {
+	case ElemFuncRef:
+		return "funcref"
+	case ElemExternRef:
+		return "externref"
+	}
+	return "unknown"
+}
+
 type FuncType struct {
 	form    ValueType   // value for the 'func' type constructor
 	params  []ValueType // parameters of the function
 	results []ValueType // results of the function
+
+	// gc holds the encoding following the form of a GC proposal type, a
+	// recursion group is a single entry.
+	gc []byte
+}
+
+// NewFuncType returns the function signature params -> results.
+func NewFuncType(params, results []ValueType) FuncType {
+	return FuncType{form: ValueFunc, params: params, results: results}
+}
+
+// Params returns the parameter types of the function.
+func (fn *FuncType) Params() []ValueType {
+	return fn.params
+}
+
+// Results returns the result types of the function.
+func (fn *FuncType) Results() []ValueType {
+	return fn.results
+}
+
+// equal reports whether fn and ft are the same signature.
+func (fn *FuncType) equal(ft *FuncType) bool {
+	return fn.form == ft.form && eqValues(fn.params, ft.params) &&
+		eqValues(fn.results, ft.results) && bytes.Equal(fn.gc, ft.gc)
 }
 
 func (fn *FuncType) String() string {
@@ -209,6 +371,21 @@ type MemoryType struct {
 	Limits ResizableLimits
 }
 
+func (gt GlobalType) String() string {
+	if gt.Mutability != 0 {
+		return "(mut " + gt.ContentType.String() + ")"
+	}
+	return gt.ContentType.String()
+}
+
+func (tt TableType) String() string {
+	return tt.ElemType.String() + " " + tt.Limits.String()
+}
+
+func (mt MemoryType) String() string {
+	return mt.Limits.String()
+}
+
 // ExternalKind indicates the kind of definition being imported or defined:
 // 0: indicates a Function import or definition
 // 1: indicates a Table import or definition
@@ -243,15 +420,68 @@ const (
 
 // ResizableLimits describes the limits of a table or memory
 type ResizableLimits struct {
-	Flags   uint32 // bit 0x1 is set if the maximum field is present
-	Initial uint32 // initial length (in units of table elements or wasm pages)
-	Maximum uint32 // only present if specified by Flags
+	Flags   uint32 // bit 0x1 is set if the maximum field is present, 0x2 if shared, 0x4 if 64-bit
+	Initial uint64 // initial length (in units of table elements or wasm pages)
+	Maximum uint64 // only present if specified by Flags
+}
+
+// Shared reports whether l are the limits of a shared memory of the threads
+// proposal, flagged by bit 0x2.
+func (l ResizableLimits) Shared() bool {
+	return l.Flags&0x2 != 0
+}
+
+// Memory64 reports whether l are the limits of a 64-bit memory of the
+// memory64 proposal, flagged by bit 0x4, which are encoded as varuint64.
+func (l ResizableLimits) Memory64() bool {
+	return l.Flags&0x4 != 0
+}
+
+func (l ResizableLimits) String() string {
+	shared := ""
+	if l.Memory64() {
+		shared = " i64"
+	}
+	if l.Shared() {
+		shared += " shared"
+	}
+	if (l.Flags & 0x1) != 0 {
+		return fmt.Sprintf("{initial %d max %d%s}", l.Initial, l.Maximum, shared)
+	}
+	return fmt.Sprintf("{initial %d%s}", l.Initial, shared)
+}
+
+// WasmPageSize is the size in bytes of a linear memory page.
+const WasmPageSize = 65536
+
+// InitialBytes returns the initial size in bytes of a memory with limits l,
+// saturated to math.MaxUint64 for 64-bit memories of 1<<48 pages and more.
+func (l ResizableLimits) InitialBytes() uint64 {
+	return pagesBytes(l.Initial)
+}
+
+// MaximumBytes returns the maximum size in bytes of a memory with limits l,
+// saturated as by InitialBytes. It reports false if no maximum is declared.
+func (l ResizableLimits) MaximumBytes() (uint64, bool) {
+	if (l.Flags & 0x1) == 0 {
+		return 0, false
+	}
+	return pagesBytes(l.Maximum), true
+}
+
+// pagesBytes returns the size in bytes of n pages, or math.MaxUint64 if it
+// overflows.
+func pagesBytes(n uint64) uint64 {
+	if n > math.MaxUint64/WasmPageSize {
+		return math.MaxUint64
+	}
+	return n * WasmPageSize
 }
 
 // InitExpr encodes an initializer expression.
-// only i32.const support, i64.const convert to i32
-// FIXME
+// only a single const or get_global is supported, Op defaults to i32.const
 type InitExpr struct {
-	Value int64
-	//Expr  []byte
+	Op    Opcode   // the opcode of the expression
+	Value int64    // value of i32/i64.const, raw bits of f32/f64.const, get_global or ref.func index, ref.null type
+	V128  [16]byte // value of v128.const, Op is Op_simd_prefix
 }
@@ -0,0 +1,445 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	errSectionOrder = errors.New("wasm: section out of order or duplicated")
+	errFuncForm     = errors.New("wasm: type is not a function type")
+	errRecGroup     = errors.New("wasm: rec group of more than one type")
+	errMultiResult  = errors.New("wasm: more than one result")
+	errLimits       = errors.New("wasm: initial size larger than maximum")
+	errMemoryPages  = errors.New("wasm: memory larger than its maximum number of pages")
+	errDupExport    = errors.New("wasm: duplicate export name")
+	errStartFunc    = errors.New("wasm: start function must be [] -> []")
+	errDataBounds   = errors.New("wasm: data segment exceeds the memory maximum")
+	errSharedMax    = errors.New("wasm: shared memory must declare a maximum")
+	errNamePosition = errors.New("wasm: name section before the code section")
+	errMutGlobal    = errors.New("wasm: exported global is mutable")
+	errMultiMemory  = errors.New("wasm: more than one memory")
+	errReserved     = errors.New("wasm: reserved byte must be zero")
+	errInitType     = errors.New("wasm: initializer does not match the global type")
+	errStartBody    = errors.New("wasm: start function has no code body")
+	errRefElem      = errors.New("wasm: element segment requires reference types")
+	errTagType      = errors.New("wasm: tag type must have no results")
+	errDataCount    = errors.New("wasm: data count does not match the data segments")
+)
+
+// maxMemoryPages is the maximum number of pages of a 32-bit memory.
+const maxMemoryPages = 65536
+
+// maxMemory64Pages is the maximum number of pages of a 64-bit memory.
+const maxMemory64Pages = 1 << 48
+
+// ValidationError reports the entry of a section which failed validation.
+type ValidationError struct {
+	Section SectionID // the section holding the invalid entry
+	Index   int       // index of the entry within the section, -1 if none
+	Offset  int64     // offset in the module of the entry or section, zero if not decoded
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	var at string
+	if e.Offset != 0 {
+		at = fmt.Sprintf(" at offset %d", e.Offset)
+	}
+	if e.Index < 0 {
+		return fmt.Sprintf("wasm: %s section%s: %v", e.Section, at, e.Err)
+	}
+	return fmt.Sprintf("wasm: %s section entry %d%s: %v", e.Section, e.Index, at, e.Err)
+}
+
+// invalid returns the ValidationError of entry idx of the section id of m,
+// located at the offset recorded by the decoder.
+func (m Module) invalid(id SectionID, idx int, err error) *ValidationError {
+	ve := &ValidationError{Section: id, Index: idx, Err: err}
+	if s, ok := m.section(id).(interface{ offsetOf(int) int64 }); ok {
+		ve.Offset = s.offsetOf(idx)
+	}
+	return ve
+}
+
+func (id SectionID) String() string {
+	switch id {
+	case UnknownID:
+		return "custom"
+	case TypeID:
+		return "type"
+	case ImportID:
+		return "import"
+	case FunctionID:
+		return "function"
+	case TableID:
+		return "table"
+	case MemoryID:
+		return "memory"
+	case GlobalID:
+		return "global"
+	case ExportID:
+		return "export"
+	case StartID:
+		return "start"
+	case ElementID:
+		return "element"
+	case CodeID:
+		return "code"
+	case DataID:
+		return "data"
+	case TagID:
+		return "tag"
+	case DataCountID:
+		return "datacount"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(id))
+}
+
+// ValidateOptions relaxes the MVP rules checked by ValidateWith to allow
+// features of later proposals.
+type ValidateOptions struct {
+	AllowMutableGlobalExport bool // mutable-globals proposal
+	AllowMultiMemory         bool // multi-memory proposal
+	AllowReferenceTypes      bool // reference-types proposal
+}
+
+// ValidateMVP validates the structure of the module against the
+// WebAssembly MVP, the code of function bodies is checked by TypeCheck.
+func (m Module) ValidateMVP() error {
+	return m.ValidateWith(ValidateOptions{})
+}
+
+// ValidateWith is like ValidateMVP with the given options.
+func (m Module) ValidateWith(opts ValidateOptions) error {
+	last := 0
+	for _, s := range m.Sections {
+		id := s.ID()
+		if id == UnknownID {
+			continue
+		}
+		order := sectionOrder(id)
+		if order <= last {
+			return m.invalid(id, -1, errSectionOrder)
+		}
+		last = order
+	}
+
+	mc := m.context()
+	for i, ft := range mc.types {
+		// the types of a rec group share its entry, shifting the indices of
+		// the types which follow it
+		if ft.form == ValueRec {
+			if n, _, err := uvarint(bytes.NewReader(ft.gc)); err != nil || n > 1 {
+				return m.invalid(TypeID, i, errRecGroup)
+			}
+		}
+		if ft.form != ValueFunc {
+			return m.invalid(TypeID, i, errFuncForm)
+		}
+		if len(ft.results) > 1 {
+			return m.invalid(TypeID, i, errMultiResult)
+		}
+	}
+
+	if fs, ok := m.section(FunctionID).(FunctionSection); ok {
+		for i, idx := range fs.Types {
+			if mc.typeAt(idx) == nil {
+				return m.invalid(FunctionID, i, errBadIndex)
+			}
+		}
+	}
+	// a defined start function without a body is reported before the
+	// function count mismatch it implies
+	if ss, ok := m.section(StartID).(StartSection); ok {
+		code, _ := m.section(CodeID).(CodeSection)
+		if def, ok := m.DefinedFuncIndex(ss.Index); ok && code.Skipped == 0 && def >= len(code.Bodies) {
+			return m.invalid(StartID, -1, errStartBody)
+		}
+	}
+	if err := m.CheckFunctionCount(); err != nil {
+		return err
+	}
+
+	if is, ok := m.section(ImportID).(ImportSection); ok {
+		for i, imp := range is.Imports {
+			var err error
+			switch typ := imp.Typ.(type) {
+			case uint32:
+				if mc.typeAt(typ) == nil {
+					err = fmt.Errorf("function type %d: %w", typ, errBadIndex)
+				}
+			case TableType:
+				err = typ.Limits.validate()
+			case MemoryType:
+				err = typ.validate()
+			}
+			if err != nil {
+				return m.invalid(ImportID, i, err)
+			}
+		}
+	}
+	if ts, ok := m.section(TableID).(TableSection); ok {
+		for i, tt := range ts.tables {
+			if err := tt.Limits.validate(); err != nil {
+				return m.invalid(TableID, i, err)
+			}
+		}
+	}
+	if ms, ok := m.section(MemoryID).(MemorySection); ok {
+		for i, mt := range ms.memories {
+			if err := mt.validate(); err != nil {
+				return m.invalid(MemoryID, i, err)
+			}
+		}
+	}
+	if mc.mems > 1 && !opts.AllowMultiMemory {
+		// the imported memories come first, report the first defined one
+		ms, _ := m.section(MemoryID).(MemorySection)
+		imported := mc.mems - len(ms.memories)
+		if imported > 1 {
+			return m.invalid(ImportID, -1, errMultiMemory)
+		}
+		return m.invalid(MemoryID, 1-imported, errMultiMemory)
+	}
+	if ts, ok := m.section(TagID).(TagSection); ok {
+		for i, tt := range ts.Tags {
+			ft := mc.typeAt(tt.Type)
+			if ft == nil {
+				return m.invalid(TagID, i, fmt.Errorf("type %d: %w", tt.Type, errBadIndex))
+			}
+			if len(ft.results) != 0 {
+				return m.invalid(TagID, i, errTagType)
+			}
+		}
+	}
+
+	if gs, ok := m.section(GlobalID).(GlobalSection); ok {
+		for i, gv := range gs.globals {
+			vt, err := mc.initType(&gv.Init)
+			if err == nil && vt != gv.Type.ContentType {
+				err = fmt.Errorf("%w: %s initializer of %s global", errInitType, vt, gv.Type.ContentType)
+			}
+			if err != nil {
+				return m.invalid(GlobalID, i, err)
+			}
+		}
+	}
+
+	if es, ok := m.section(ExportID).(ExportSection); ok {
+		names := make(map[string]bool, len(es.Exports))
+		for i, ee := range es.Exports {
+			if names[ee.Field] {
+				return m.invalid(ExportID, i, errDupExport)
+			}
+			names[ee.Field] = true
+			// functions below the imported count re-export an import
+			if int64(ee.Index) >= int64(mc.numEntities(ee.Kind)) {
+				return m.invalid(ExportID, i,
+					fmt.Errorf("%s %d: %w", ee.Kind, ee.Index, errBadIndex))
+			}
+			if ee.Kind == GlobalKind && !opts.AllowMutableGlobalExport &&
+				int64(ee.Index) < int64(len(mc.globals)) && mc.globals[ee.Index].Mutability != 0 {
+				return m.invalid(ExportID, i, errMutGlobal)
+			}
+		}
+	}
+
+	if es, ok := m.section(ElementID).(ElementSection); ok {
+		for i, seg := range es.elements {
+			if (seg.Flags != 0 || len(seg.Exprs) != 0) && !opts.AllowReferenceTypes {
+				return m.invalid(ElementID, i, errRefElem)
+			}
+			// passive and declarative segments have no table
+			if seg.Flags&0x1 == 0 && int64(seg.Index) >= int64(mc.tables) {
+				return m.invalid(ElementID, i,
+					fmt.Errorf("table %d: %w", seg.Index, errBadIndex))
+			}
+			for j, idx := range seg.Elems {
+				if int64(idx) >= int64(len(mc.funcs)) {
+					return m.invalid(ElementID, i,
+						fmt.Errorf("element %d: function %d: %w", j, idx, errBadIndex))
+				}
+			}
+			for j, ie := range seg.Exprs {
+				if ie.Op == Op_ref_func && uint64(ie.Value) >= uint64(len(mc.funcs)) {
+					return m.invalid(ElementID, i,
+						fmt.Errorf("element %d: function %d: %w", j, ie.Value, errBadIndex))
+				}
+			}
+		}
+	}
+
+	if ds, ok := m.section(DataID).(DataSection); ok {
+		for i, seg := range ds.segments {
+			if int64(seg.Index) >= int64(mc.mems) {
+				return m.invalid(DataID, i, fmt.Errorf("memory %d: %w", seg.Index, errBadIndex))
+			}
+			if err := m.checkDataBounds(&seg); err != nil {
+				return m.invalid(DataID, i, err)
+			}
+		}
+	}
+	if dc, ok := m.section(DataCountID).(DataCountSection); ok {
+		ds, _ := m.section(DataID).(DataSection)
+		if int64(dc.Count) != int64(len(ds.segments)) {
+			return m.invalid(DataCountID, -1,
+				fmt.Errorf("%w: %d, want %d", errDataCount, dc.Count, len(ds.segments)))
+		}
+	}
+
+	if ss, ok := m.section(StartID).(StartSection); ok {
+		ft := mc.funcType(ss.Index)
+		if ft == nil {
+			return m.invalid(StartID, -1, errBadIndex)
+		}
+		if len(ft.params) != 0 || len(ft.results) != 0 {
+			return m.invalid(StartID, -1, errStartFunc)
+		}
+	}
+	return nil
+}
+
+// ValidateCode checks that every call in the function bodies targets an
+// existing function and every call_indirect an existing type, and that the
+// reserved bytes of call_indirect, memory.size and memory.grow are zero.
+func (m Module) ValidateCode() error {
+	return m.ValidateCodeWith(ValidateOptions{})
+}
+
+// ValidateCodeWith is like ValidateCode with the given options: the table
+// index of call_indirect may be non-zero with reference-types, the memory
+// index of memory.size and memory.grow with multi-memory.
+func (m Module) ValidateCodeWith(opts ValidateOptions) error {
+	code, ok := m.section(CodeID).(CodeSection)
+	if !ok {
+		return nil
+	}
+	nFuncs := int64(m.NumFunctions())
+	var nTypes int64
+	if ts, ok := m.section(TypeID).(TypeSection); ok {
+		nTypes = int64(len(ts.Types))
+	}
+	for i, fb := range code.Bodies {
+		idx := m.AbsoluteFuncIndex(i)
+		insns, err := fb.decode(idx)
+		if err != nil {
+			return err
+		}
+		for _, ins := range insns {
+			if ins.Op == Op_call && int64(ins.Index) >= nFuncs ||
+				ins.Op == Op_call_indirect && int64(ins.Index) >= nTypes {
+				return &CodeError{Func: idx, Offset: ins.Offset, Err: errBadIndex}
+			}
+			if ins.Op == Op_call_indirect && ins.Table != 0 && !opts.AllowReferenceTypes ||
+				opImmediate(ins.Op) == immMemory && ins.Index != 0 && !opts.AllowMultiMemory {
+				return &CodeError{Func: idx, Offset: ins.Offset, Err: errReserved}
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateNameSectionPlacement checks that the "name" custom section, if
+// any, follows the code section as required by convention.
+func (m Module) ValidateNameSectionPlacement() error {
+	code := m.sectionIndex(CodeID)
+	for i, s := range m.Sections {
+		if ns, ok := s.(NameSection); ok && ns.Name == "name" && i < code {
+			return &ValidationError{Section: UnknownID, Index: -1, Offset: ns.off, Err: errNamePosition}
+		}
+	}
+	return nil
+}
+
+// sectionOrder returns the position of a known section within a module.
+func sectionOrder(id SectionID) int {
+	switch id {
+	case TagID:
+		// the tag section follows the memory section
+		return int(MemoryID)*2 + 1
+	case DataCountID:
+		// the data count section precedes the code section
+		return int(ElementID)*2 + 1
+	}
+	return int(id) * 2
+}
+
+// initType returns the type of the value of an initializer expression.
+func (mc *moduleContext) initType(ie *InitExpr) (ValueType, error) {
+	switch ie.Op {
+	case Op_unreachable, Op_i32_const:
+		return ValueI32, nil
+	case Op_i64_const:
+		return ValueI64, nil
+	case Op_f32_const:
+		return ValueF32, nil
+	case Op_f64_const:
+		return ValueF64, nil
+	case Op_simd_prefix:
+		return ValueV128, nil
+	case Op_ref_null:
+		return ValueType(ie.Value), nil
+	case Op_ref_func:
+		return ValueFuncRef, nil
+	case Op_get_global:
+		// only immutable imported globals are constant
+		if uint64(ie.Value) >= uint64(len(mc.globals)) {
+			return 0, fmt.Errorf("global %d: %w", ie.Value, errBadIndex)
+		}
+		if uint64(ie.Value) >= uint64(mc.importedGlobals) {
+			return 0, fmt.Errorf("%w: get_global of defined global %d", errInitType, ie.Value)
+		}
+		if mc.globals[ie.Value].Mutability != 0 {
+			return 0, fmt.Errorf("%w: get_global of mutable global %d", errInitType, ie.Value)
+		}
+		return mc.globals[ie.Value].ContentType, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported %s initializer", errInitType, ie.Op)
+}
+
+// checkDataBounds checks that a data segment with a constant offset fits
+// within the maximum size of its memory, if one is declared.
+func (m Module) checkDataBounds(ds *DataSegment) error {
+	if ds.Offset.Op != Op_i32_const && ds.Offset.Op != Op_unreachable {
+		return nil
+	}
+	limits, ok := m.memoryLimits(ds.Index)
+	if !ok {
+		return nil
+	}
+	max, ok := limits.MaximumBytes()
+	if ok && uint64(uint32(ds.Offset.Value))+uint64(len(ds.Data)) > max {
+		return errDataBounds
+	}
+	return nil
+}
+
+func (l ResizableLimits) validate() error {
+	if (l.Flags&0x1) != 0 && l.Initial > l.Maximum {
+		return errLimits
+	}
+	return nil
+}
+
+func (mt MemoryType) validate() error {
+	if err := mt.Limits.validate(); err != nil {
+		return err
+	}
+	max := uint64(maxMemoryPages)
+	if mt.Limits.Memory64() {
+		max = maxMemory64Pages
+	}
+	if mt.Limits.Initial > max || (mt.Limits.Flags&0x1) != 0 && mt.Limits.Maximum > max {
+		return errMemoryPages
+	}
+	if mt.Limits.Shared() && (mt.Limits.Flags&0x1) == 0 {
+		return errSharedMax
+	}
+	return nil
+}
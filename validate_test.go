@@ -0,0 +1,443 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// checkValidation asserts that err is a *ValidationError for section id
+// wrapping want.
+func checkValidation(t *testing.T, err error, id SectionID, want error) {
+	t.Helper()
+	ve, ok := err.(*ValidationError)
+	if !ok || ve.Section != id || !errors.Is(ve.Err, want) {
+		t.Errorf("got %v, want %v in the %s section", err, want, id)
+	}
+}
+
+func TestValidateMVP(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("ValidateMVP(hello.wasm): %v", err)
+	}
+
+	// duplicated export name
+	exp := mod.section(ExportID).(ExportSection)
+	exp.Exports = append(exp.Exports, exp.Exports[1])
+	dup := Module{Header: mod.Header, Sections: append([]Section{}, mod.Sections...)}
+	dup.Sections[6] = exp
+	checkValidation(t, dup.ValidateMVP(), ExportID, errDupExport)
+
+	// data section before the code section
+	swap := Module{Header: mod.Header, Sections: append([]Section{}, mod.Sections...)}
+	swap.Sections[7], swap.Sections[8] = swap.Sections[8], swap.Sections[7]
+	checkValidation(t, swap.ValidateMVP(), CodeID, errSectionOrder)
+
+	// code section missing a body
+	short := codeModule(0x0b)
+	short.Sections[3] = CodeSection{}
+	checkValidation(t, short.ValidateMVP(), CodeID, errFuncCount)
+
+	// memory with initial > maximum
+	bad := codeModule(0x0b)
+	bad.Sections[2] = MemorySection{memories: []MemoryType{
+		{Limits: ResizableLimits{Flags: 1, Initial: 2, Maximum: 1}}}}
+	checkValidation(t, bad.ValidateMVP(), MemoryID, errLimits)
+}
+
+func TestValidationErrorOffset(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the function section of hello.wasm refers to type 1, point its
+	// entry at the missing type 5
+	i := bytes.Index(b, []byte{0x03, 0x02, 0x01, 0x01})
+	if i < 0 {
+		t.Fatal("function section not found")
+	}
+	b[i+3] = 5
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mod.ValidateMVP()
+	checkValidation(t, err, FunctionID, errBadIndex)
+	if ve, ok := err.(*ValidationError); ok && ve.Offset != int64(i+3) {
+		t.Errorf("got offset %d, want %d", ve.Offset, i+3)
+	}
+
+	// a module built in memory has no offsets
+	short := codeModule(0x0b)
+	short.Sections[3] = CodeSection{}
+	if ve, ok := short.ValidateMVP().(*ValidationError); !ok || ve.Offset != 0 {
+		t.Errorf("got %v, want a validation error without offset", ve)
+	}
+}
+
+func TestValidateDataBounds(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.Sections[2] = MemorySection{memories: []MemoryType{
+		{Limits: ResizableLimits{Flags: 1, Initial: 1, Maximum: 1}}}}
+	data := DataSection{segments: []DataSegment{
+		{Offset: InitExpr{Op: Op_i32_const, Value: 0}, Data: []byte("hello")},
+		{Offset: InitExpr{Op: Op_i32_const, Value: WasmPageSize - 5}, Data: []byte("world")},
+	}}
+	mod.Sections = append(mod.Sections, data)
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("ValidateMVP: %v", err)
+	}
+
+	data.segments = append(data.segments,
+		DataSegment{Offset: InitExpr{Op: Op_i32_const, Value: WasmPageSize - 4}, Data: []byte("world")})
+	mod.Sections[4] = data
+	err := mod.ValidateMVP()
+	checkValidation(t, err, DataID, errDataBounds)
+	if ve, ok := err.(*ValidationError); ok && ve.Index != 2 {
+		t.Errorf("got segment %d, want segment 2", ve.Index)
+	}
+
+	// without a maximum the memory may grow to fit the segment
+	mod.Sections[2] = MemorySection{memories: []MemoryType{
+		{Limits: ResizableLimits{Initial: 1}}}}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+
+	// the largest 64-bit memory fits any segment
+	mod.Sections[2] = MemorySection{memories: []MemoryType{
+		{Limits: ResizableLimits{Flags: 0x5, Initial: 1, Maximum: maxMemory64Pages}}}}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("64-bit memory of %d pages: %v", uint64(maxMemory64Pages), err)
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	mod := codeModule(0x10, 0x00, 0x41, 0x00, 0x11, 0x00, 0x00, 0x0b) // call 0; call_indirect (type 0)
+	if err := mod.ValidateCode(); err != nil {
+		t.Errorf("ValidateCode: %v", err)
+	}
+
+	tests := []struct {
+		code   []byte
+		offset int
+	}{
+		{[]byte{0x01, 0x10, 0xe7, 0x07, 0x0b}, 1},       // nop; call 999
+		{[]byte{0x41, 0x00, 0x11, 0x05, 0x00, 0x0b}, 2}, // call_indirect (type 5)
+	}
+	for _, tt := range tests {
+		err := codeModule(tt.code...).ValidateCode()
+		ce, ok := err.(*CodeError)
+		if !ok {
+			t.Errorf("%x: got %v, want a CodeError", tt.code, err)
+			continue
+		}
+		if ce.Func != 0 || ce.Offset != tt.offset || ce.Err != errBadIndex {
+			t.Errorf("%x: got func %d offset %d %v, want func 0 offset %d %v",
+				tt.code, ce.Func, ce.Offset, ce.Err, tt.offset, errBadIndex)
+		}
+	}
+}
+
+func TestValidateReservedByte(t *testing.T) {
+	tests := []struct {
+		code []byte
+		opts ValidateOptions
+	}{
+		// i32.const 0; call_indirect (type 0) (table 1)
+		{[]byte{0x41, 0x00, 0x11, 0x00, 0x01, 0x0b}, ValidateOptions{AllowReferenceTypes: true}},
+		// memory.size 1; drop
+		{[]byte{0x3f, 0x01, 0x1a, 0x0b}, ValidateOptions{AllowMultiMemory: true}},
+		// memory.size 2; drop
+		{[]byte{0x3f, 0x02, 0x1a, 0x0b}, ValidateOptions{AllowMultiMemory: true}},
+		// i32.const 1; memory.grow 2; drop
+		{[]byte{0x41, 0x01, 0x40, 0x02, 0x1a, 0x0b}, ValidateOptions{AllowMultiMemory: true}},
+	}
+	for _, tt := range tests {
+		mod := codeModule(tt.code...)
+		err := mod.ValidateCode()
+		if ce, ok := err.(*CodeError); !ok || ce.Err != errReserved {
+			t.Errorf("%x: got %v, want %v", tt.code, err, errReserved)
+		}
+		if err := mod.ValidateCodeWith(tt.opts); err != nil {
+			t.Errorf("%x: ValidateCodeWith(%+v): %v", tt.code, tt.opts, err)
+		}
+	}
+}
+
+func TestSharedMemory(t *testing.T) {
+	// memory section with a shared memory of 1 to 2 pages
+	b := append(append([]byte{}, wasmHeader...), 0x05, 0x04, 0x01, 0x03, 0x01, 0x02)
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, ok := mod.memoryLimits(0)
+	if !ok || !limits.Shared() || limits.Maximum != 2 {
+		t.Errorf("got limits %+v, want shared with maximum 2", limits)
+	}
+	if s := limits.String(); s != "{initial 1 max 2 shared}" {
+		t.Errorf("String() = %q", s)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	if got, err := mod.Bytes(); err != nil || !bytes.Equal(got, b) {
+		t.Errorf("WriteTo: got %x, %v, want %x", got, err, b)
+	}
+
+	// the flags alone decide the shared bit
+	limits.Flags &^= 0x2
+	unshared := Module{Header: mod.Header, Sections: []Section{
+		MemorySection{memories: []MemoryType{{Limits: limits}}}}}
+	if got := reparse(t, unshared); got.section(MemoryID).(MemorySection).memories[0].Limits.Shared() {
+		t.Error("flags without bit 0x2 are encoded as shared")
+	}
+
+	// a shared memory without a maximum
+	b = append(append([]byte{}, wasmHeader...), 0x05, 0x03, 0x01, 0x02, 0x01)
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	checkValidation(t, mod.ValidateMVP(), MemoryID, errSharedMax)
+}
+
+func TestValidateDataMemory(t *testing.T) {
+	mod := codeModule(0x0b)
+	data := DataSection{segments: []DataSegment{
+		{Offset: InitExpr{Op: Op_i32_const, Value: 0}, Data: []byte("hello")},
+	}}
+	// no memory, imported or defined
+	mod.Sections = append([]Section{mod.Sections[0], mod.Sections[1], mod.Sections[3]}, data)
+	err := mod.ValidateMVP()
+	checkValidation(t, err, DataID, errBadIndex)
+	if err != nil && !strings.Contains(err.Error(), "memory 0") {
+		t.Errorf("got %v, want memory 0", err)
+	}
+
+	// an imported memory
+	mod.Sections = append([]Section{mod.Sections[0], ImportSection{Imports: []ImportEntry{
+		{Module: "env", Field: "memory", Kind: MemoryKind, Typ: MemoryType{Limits: ResizableLimits{Initial: 1}}},
+	}}}, mod.Sections[1:]...)
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+}
+
+func TestValidateGlobalInitType(t *testing.T) {
+	mod := codeModule(0x0b)
+	globals := GlobalSection{globals: []GlobalVariable{
+		{Type: GlobalType{ContentType: ValueF32}, Init: InitExpr{Op: Op_f32_const}},
+		{Type: GlobalType{ContentType: ValueI64}, Init: InitExpr{Op: Op_i64_const, Value: 7}},
+	}}
+	mod.addSection(globals)
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("ValidateMVP: %v", err)
+	}
+
+	globals.globals[0].Init = InitExpr{Op: Op_i32_const, Value: 1}
+	err := mod.ValidateMVP()
+	checkValidation(t, err, GlobalID, errInitType)
+	if ve, ok := err.(*ValidationError); ok && ve.Index != 0 {
+		t.Errorf("got global %d, want global 0", ve.Index)
+	}
+
+	// get_global of an immutable imported global
+	mod.addSection(ImportSection{Imports: []ImportEntry{
+		{Module: "env", Field: "f", Kind: GlobalKind, Typ: GlobalType{ContentType: ValueF32}},
+		{Module: "env", Field: "g", Kind: GlobalKind, Typ: GlobalType{ContentType: ValueF32, Mutability: 1}},
+	}})
+	globals.globals[0].Init = InitExpr{Op: Op_get_global, Value: 0}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("get_global of an imported global: %v", err)
+	}
+
+	// get_global of a mutable imported global
+	globals.globals[0].Init = InitExpr{Op: Op_get_global, Value: 1}
+	checkValidation(t, mod.ValidateMVP(), GlobalID, errInitType)
+	// get_global of a defined global, even of the same type
+	globals.globals[0].Init = InitExpr{Op: Op_get_global, Value: 2}
+	checkValidation(t, mod.ValidateMVP(), GlobalID, errInitType)
+	globals.globals[0].Init = InitExpr{Op: Op_get_global, Value: 5}
+	checkValidation(t, mod.ValidateMVP(), GlobalID, errBadIndex)
+}
+
+func TestValidateExportIndex(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// re-export the imported function 0
+	if err := mod.AddExport("finish", FunctionKind, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("re-exported import: %v", err)
+	}
+
+	tests := []struct {
+		kind  ExternalKind
+		index uint32
+	}{
+		{FunctionKind, 2},
+		{TableKind, 1},
+		{MemoryKind, 1},
+		{GlobalKind, 1},
+	}
+	for _, tt := range tests {
+		bad := Module{Header: mod.Header, Sections: append([]Section(nil), mod.Sections...)}
+		es := bad.section(ExportID).(ExportSection)
+		es.Exports = append(es.Exports[:len(es.Exports):len(es.Exports)],
+			ExportEntry{Field: "bad", Kind: tt.kind, Index: tt.index})
+		bad.Sections[bad.sectionIndex(ExportID)] = es
+		err := bad.ValidateMVP()
+		checkValidation(t, err, ExportID, errBadIndex)
+		if ve, ok := err.(*ValidationError); ok && ve.Index != len(es.Exports)-1 {
+			t.Errorf("%s %d: got export %d", tt.kind, tt.index, ve.Index)
+		}
+	}
+}
+
+func TestValidateStartBody(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.addSection(StartSection{Index: 0})
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("ValidateMVP: %v", err)
+	}
+
+	// function 1 is declared without a body
+	mod.Sections[1] = FunctionSection{Types: []uint32{0, 0}}
+	mod.Sections[mod.sectionIndex(StartID)] = StartSection{Index: 1}
+	checkValidation(t, mod.ValidateMVP(), StartID, errStartBody)
+
+	// an imported start function has no body
+	imported := codeModule(0x0b)
+	imported.Sections = append([]Section{imported.Sections[0], ImportSection{Imports: []ImportEntry{
+		{Module: "env", Field: "init", Kind: FunctionKind, Typ: uint32(0)},
+	}}}, imported.Sections[1:]...)
+	imported.addSection(StartSection{Index: 0})
+	if err := imported.ValidateMVP(); err != nil {
+		t.Errorf("imported start function: %v", err)
+	}
+}
+
+func TestValidateElements(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.Sections[1] = FunctionSection{Types: []uint32{0, 0, 0}}
+	mod.Sections[3] = CodeSection{Bodies: []FunctionBody{{Code: []byte{0x0b}}, {Code: []byte{0x0b}}, {Code: []byte{0x0b}}}}
+	mod.addSection(TableSection{tables: []TableType{{ElemType: ElemFuncRef, Limits: ResizableLimits{Initial: 4}}}})
+	elems := ElementSection{elements: []ElemSegment{
+		{Offset: InitExpr{Op: Op_i32_const}, Elems: []uint32{0, 1, 2}},
+	}}
+	mod.addSection(elems)
+	if err := mod.ValidateMVP(); err != nil {
+		t.Fatalf("ValidateMVP: %v", err)
+	}
+
+	elems.elements = append(elems.elements,
+		ElemSegment{Offset: InitExpr{Op: Op_i32_const, Value: 3}, Elems: []uint32{2, 100}})
+	mod.Sections[mod.sectionIndex(ElementID)] = elems
+	err := mod.ValidateMVP()
+	checkValidation(t, err, ElementID, errBadIndex)
+	if ve, ok := err.(*ValidationError); ok && (ve.Index != 1 || !strings.Contains(ve.Error(), "function 100")) {
+		t.Errorf("got %v, want segment 1 function 100", err)
+	}
+
+	// a segment for a missing table
+	elems.elements = []ElemSegment{{Index: 1, Offset: InitExpr{Op: Op_i32_const}}}
+	mod.Sections[mod.sectionIndex(ElementID)] = elems
+	checkValidation(t, mod.ValidateMVP(), ElementID, errBadIndex)
+}
+
+func TestValidateImportType(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.Sections[0] = TypeSection{Types: []FuncType{{form: ValueFunc}, {form: ValueFunc}}}
+	mod.addSection(ImportSection{Imports: []ImportEntry{
+		{Module: "env", Field: "f", Kind: FunctionKind, Typ: uint32(1)},
+		{Module: "env", Field: "g", Kind: FunctionKind, Typ: uint32(50)},
+	}})
+	err := mod.ValidateMVP()
+	checkValidation(t, err, ImportID, errBadIndex)
+	if ve, ok := err.(*ValidationError); ok && (ve.Index != 1 || !strings.Contains(ve.Error(), "type 50")) {
+		t.Errorf("got %v, want import 1 of type 50", err)
+	}
+}
+
+func TestValidateNameSectionPlacement(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.SetModuleName("hello")
+	if err := mod.ValidateNameSectionPlacement(); err != nil {
+		t.Errorf("name section last: %v", err)
+	}
+
+	// move the name section before the code section
+	n := len(mod.Sections)
+	name := mod.Sections[n-1]
+	copy(mod.Sections[8:], mod.Sections[7:n-1])
+	mod.Sections[7] = name
+	mod = reparse(t, mod)
+	if _, ok := mod.Sections[7].(NameSection); !ok {
+		t.Fatalf("section 7 is %T, want the name section", mod.Sections[7])
+	}
+	checkValidation(t, mod.ValidateNameSectionPlacement(), UnknownID, errNamePosition)
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+}
+
+func TestValidateMutableGlobalExport(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.addSection(GlobalSection{globals: []GlobalVariable{
+		{Type: GlobalType{ContentType: ValueI32, Mutability: 1}, Init: InitExpr{Op: Op_i32_const}},
+	}})
+	if err := mod.AddExport("counter", GlobalKind, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkValidation(t, mod.ValidateMVP(), ExportID, errMutGlobal)
+	if err := mod.ValidateWith(ValidateOptions{AllowMutableGlobalExport: true}); err != nil {
+		t.Errorf("ValidateWith(AllowMutableGlobalExport): %v", err)
+	}
+}
+
+func TestValidateMemoryCount(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.addSection(ImportSection{Imports: []ImportEntry{
+		{Module: "env", Field: "memory", Kind: MemoryKind, Typ: MemoryType{Limits: ResizableLimits{Initial: 1}}},
+	}})
+	err := mod.ValidateMVP()
+	checkValidation(t, err, MemoryID, errMultiMemory)
+	if ve, ok := err.(*ValidationError); ok && ve.Index != 0 {
+		t.Errorf("got index %d, want 0", ve.Index)
+	}
+	if err := mod.ValidateWith(ValidateOptions{AllowMultiMemory: true}); err != nil {
+		t.Errorf("ValidateWith(AllowMultiMemory): %v", err)
+	}
+}
+
+func TestValidateDataCount(t *testing.T) {
+	mod := codeModule(0x0b)
+	mod.addSection(DataCountSection{Count: 1})
+	checkValidation(t, mod.ValidateMVP(), DataCountID, errDataCount)
+
+	mod.addSection(DataSection{segments: []DataSegment{
+		{Offset: InitExpr{Op: Op_i32_const, Value: 0}, Data: []byte("hello")},
+	}})
+	if err := mod.ValidateMVP(); err != nil {
+		t.Errorf("ValidateMVP: %v", err)
+	}
+	mod.setSection(DataCountSection{Count: 2})
+	checkValidation(t, mod.ValidateMVP(), DataCountID, errDataCount)
+}
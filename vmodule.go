@@ -8,12 +8,11 @@ import (
 	"bytes"
 	"errors"
 	"io"
-	"log"
 )
 
 var (
 	errHead          = errors.New("wasm: header missing")
-	errExports       = errors.New("wasm: MUST only 2 exports")
+	errExports       = errors.New("wasm: MUST only main, memory and allowed exports")
 	errExpMiss       = errors.New("wasm: exports no main or memory")
 	errNoDebug       = errors.New("wasm: Release w/out \"debug\" module")
 	errExpError      = errors.New("wasm: exports main or memory sig error")
@@ -36,6 +35,35 @@ type ValModule struct {
 	bCustom      bool
 	bDebug       bool
 	buff         []byte
+	lr           io.LimitedReader // reader of the current section
+
+	// AllowedExports lists the exports kept by name and kind, all others
+	// are dropped. If nil only "main" and "memory" are kept, both of them
+	// are always required by Validate.
+	AllowedExports map[string]ExternalKind
+
+	// MaxImports is the number of imports accepted by Validate, zero is no
+	// limit. Exceeding it is reported as a *LimitError.
+	MaxImports int
+
+	// MaxCodeSize is the size in bytes of the encoded module accepted by
+	// Validate, zero is no limit. Ethereum limits contracts to 16KB.
+	// Exceeding it is reported as a *LimitError.
+	MaxCodeSize int
+	size        int // size of the module read
+}
+
+// defaultExports are the exports of an ewasm contract.
+var defaultExports = map[string]ExternalKind{
+	"main":   FunctionKind,
+	"memory": MemoryKind,
+}
+
+func (vm *ValModule) allowedExports() map[string]ExternalKind {
+	if vm.AllowedExports == nil {
+		return defaultExports
+	}
+	return vm.AllowedExports
 }
 
 func (vm *ValModule) ReadValModule(inbuf []byte) error {
@@ -46,7 +74,11 @@ func (vm *ValModule) ReadValModule(inbuf []byte) error {
 	if d.err != nil {
 		return errHead
 	}
-	vm.buff = inbuf[:8]
+	vm.size = len(inbuf)
+	if !vm.OnlyValidate {
+		// copy the header, appending to inbuf would overwrite the input
+		vm.buff = append([]byte{}, inbuf[:8]...)
+	}
 	for {
 		if err := vm.readSection(&d); err != nil {
 			if err == io.EOF {
@@ -60,12 +92,17 @@ func (vm *ValModule) ReadValModule(inbuf []byte) error {
 
 func (vm *ValModule) readSection(d *decoder) error {
 	var (
-		id  uint32
-		sz  uint32
-		sec Section
+		id uint32
+		sz uint32
 	)
-	out := new(bytes.Buffer)
-	dr := io.TeeReader(d.r, out)
+	// the sections are copied to the output as they are read, unless
+	// only validating
+	var out *bytes.Buffer
+	dr := d.r
+	if !vm.OnlyValidate {
+		out = new(bytes.Buffer)
+		dr = io.TeeReader(d.r, out)
+	}
 	d.readVarU7(dr, &id)
 	if d.err != nil {
 		return d.err
@@ -75,7 +112,8 @@ func (vm *ValModule) readSection(d *decoder) error {
 		return errReadSection
 	}
 
-	r := &io.LimitedReader{R: dr, N: int64(sz)}
+	vm.lr = io.LimitedReader{R: dr, N: int64(sz)}
+	r := &vm.lr
 	switch SectionID(id) {
 	case TypeID:
 		d.readTypeSection(r, &vm.typ)
@@ -86,25 +124,24 @@ func (vm *ValModule) readSection(d *decoder) error {
 	case ExportID:
 		var s ExportSection
 		d.readExportSection(r, &s)
+		allowed := vm.allowedExports()
 		for _, ep := range s.Exports {
-			if (ep.Field == "main" && ep.Kind == FunctionKind) ||
-				(ep.Field == "memory" && ep.Kind == MemoryKind) {
+			if kind, ok := allowed[ep.Field]; ok && kind == ep.Kind {
 				//log.Printf("Got export %s %s\n", ep.Field, ep.Kind)
 				vm.exp.Exports = append(vm.exp.Exports, ep)
-				if len(vm.exp.Exports) >= 2 {
+				if len(vm.exp.Exports) >= len(allowed) {
 					break
 				}
 			}
 		}
 	default:
-		buf := make([]byte, sz)
-		d.read(r, buf)
+		d.skip(r, int64(sz))
 	}
 	if d.err != nil {
 		return errReadSection
 	}
 	if r.N != 0 {
-		log.Printf("wasm: N=%d bytes unread! (section=%d)\n", r.N, sec.ID())
+		logf("wasm: N=%d bytes unread! (section=%d)\n", r.N, id)
 		return errReadSection
 	}
 	switch SectionID(id) {
@@ -127,7 +164,7 @@ func (vm *ValModule) readSection(d *decoder) error {
 				ebuff = append(ebuff, uv32.bytes()...)
 				obuf = append(obuf, ebuff...)
 				/*
-					log.Printf("encode export %s len: %d, %v\n", ep.Field,
+					logf("encode export %s len: %d, %v\n", ep.Field,
 						len(ebuff), ebuff)
 				*/
 			}
@@ -233,14 +270,14 @@ func eqValues(lv, rv []ValueType) bool {
 func solveImport(modName string, fn string, typ *FuncType) bool {
 	verify := func(mm map[string]funcMap) bool {
 		if sig, ok := mm[fn]; !ok {
-			log.Printf("unsolved import: mod(%s) func(%s)\n", modName, fn)
+			logf("unsolved import: mod(%s) func(%s)\n", modName, fn)
 			return false
 		} else if !eqValues(typ.params, sig.params) {
-			log.Printf("param sig dismatch %v want %v\n", sig.params,
+			logf("param sig dismatch %v want %v\n", sig.params,
 				typ.params)
 			return false
 		} else if !eqValues(typ.results, sig.results) {
-			log.Printf("result sig dismatch %v want %v\n", sig.results,
+			logf("result sig dismatch %v want %v\n", sig.results,
 				typ.results)
 			return false
 		}
@@ -249,7 +286,7 @@ func solveImport(modName string, fn string, typ *FuncType) bool {
 	if modName == "debug" {
 		return verify(dbgMap)
 	} else if modName != "ethereum" {
-		log.Printf("unknown module: %s\n", modName)
+		logf("unknown module: %s\n", modName)
 		return false
 	}
 	return verify(ethMap)
@@ -263,7 +300,7 @@ func (vm *ValModule) Validate() error {
 	if vm.OnlyValidate && vm.bCustom {
 		return errHasCustom
 	}
-	if len(vm.exp.Exports) != 2 {
+	if len(vm.exp.Exports) < 2 || len(vm.exp.Exports) > len(vm.allowedExports()) {
 		return errExports
 	}
 	if ep := vm.findExport("main"); ep == nil {
@@ -279,6 +316,12 @@ func (vm *ValModule) Validate() error {
 	} else if ep.Kind != MemoryKind || ep.Index != 0 {
 		return errExpError
 	}
+	if vm.MaxCodeSize > 0 && vm.size > vm.MaxCodeSize {
+		return &LimitError{Limit: "code bytes", Max: vm.MaxCodeSize}
+	}
+	if vm.MaxImports > 0 && len(vm.imp.Imports) > vm.MaxImports {
+		return &LimitError{Limit: "imports", Max: vm.MaxImports}
+	}
 	// shall we validate import
 	for _, imp := range vm.imp.Imports {
 		if imp.Kind != FunctionKind {
@@ -292,10 +335,10 @@ func (vm *ValModule) Validate() error {
 			return errNoDebug
 		}
 		if idx, ok := imp.Typ.(uint32); !ok {
-			log.Printf("func idx not uint32: %v\n", imp.Typ)
+			logf("func idx not uint32: %v\n", imp.Typ)
 			return errImportFunc
 		} else if int(idx) >= len(vm.typ.Types) {
-			log.Printf("no func sig for idx: %d\n", idx)
+			logf("no func sig for idx: %d\n", idx)
 			return errImportFunc
 		} else if !solveImport(imp.Module, imp.Field, &vm.typ.Types[idx]) {
 			return errImportFunc
@@ -311,3 +354,69 @@ func (vm *ValModule) HasDebug() bool {
 func (vm *ValModule) Bytes() []byte {
 	return vm.buff
 }
+
+// ValidateAndStrip validates the ewasm contract in and returns it with the
+// custom sections and the exports other than main and memory stripped, as
+// done by cmd/ewasm-val.
+func ValidateAndStrip(in []byte) (out []byte, err error) {
+	var vm ValModule
+	if err := vm.ReadValModule(in); err != nil {
+		return nil, err
+	}
+	if err := vm.Validate(); err != nil {
+		return nil, err
+	}
+	return vm.Bytes(), nil
+}
+
+// BuildEwasmModule encodes an ewasm contract exporting main and a memory of
+// one page holding data at offset 0. The imports must be functions, a nil
+// Typ is resolved from the ethereum and debug host functions, otherwise Typ
+// holds the FuncType of the import.
+func BuildEwasmModule(imports []ImportEntry, main FunctionBody, data []byte) ([]byte, error) {
+	m := Module{Header: ModuleHeader{Magic: magicWASM, Version: 1}}
+	is := ImportSection{Imports: make([]ImportEntry, len(imports))}
+	for i, imp := range imports {
+		if imp.Kind != FunctionKind {
+			return nil, errImportNotFunc
+		}
+		var sig FuncType
+		switch typ := imp.Typ.(type) {
+		case FuncType:
+			sig = typ
+		case nil:
+			mm := ethMap
+			if imp.Module == "debug" {
+				mm = dbgMap
+			} else if imp.Module != "ethereum" {
+				return nil, errImportFunc
+			}
+			fm, ok := mm[imp.Field]
+			if !ok {
+				return nil, errImportFunc
+			}
+			sig = NewFuncType(fm.params, fm.results)
+		default:
+			return nil, errEncode
+		}
+		imp.Typ = m.typeIndex(sig)
+		is.Imports[i] = imp
+	}
+	mainType := m.typeIndex(NewFuncType(nil, nil))
+	if len(is.Imports) > 0 {
+		m.addSection(is)
+	}
+	m.addSection(FunctionSection{Types: []uint32{mainType}})
+	m.addSection(MemorySection{memories: []MemoryType{{Limits: ResizableLimits{Initial: 1}}}})
+	m.addSection(ExportSection{Exports: []ExportEntry{
+		{Field: "main", Kind: FunctionKind, Index: uint32(len(imports))},
+		{Field: "memory", Kind: MemoryKind, Index: 0},
+	}})
+	m.addSection(CodeSection{Bodies: []FunctionBody{main}})
+	if len(data) > 0 {
+		m.addSection(DataSection{segments: []DataSegment{
+			{Offset: InitExpr{Op: Op_i32_const}, Data: data},
+		}})
+	}
+	return m.Bytes()
+}
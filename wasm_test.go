@@ -7,6 +7,9 @@ package wasm
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -38,3 +41,444 @@ func TestEnVar(t *testing.T) {
 		}
 	}
 }
+
+func TestFuncIndex(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := mod.NumImportedFuncs(); n != 1 {
+		t.Errorf("NumImportedFuncs() = %d, want 1", n)
+	}
+	if n := mod.NumFunctions(); n != 2 {
+		t.Errorf("NumFunctions() = %d, want 2", n)
+	}
+	if _, ok := mod.DefinedFuncIndex(0); ok {
+		t.Errorf("DefinedFuncIndex(0) reports a defined function for an import")
+	}
+	if _, ok := mod.DefinedFuncIndex(2); ok {
+		t.Errorf("DefinedFuncIndex(2) reports an out of range function")
+	}
+	idx, ok := mod.DefinedFuncIndex(1)
+	if !ok || idx != 0 {
+		t.Errorf("DefinedFuncIndex(1) = %d, %v, want 0, true", idx, ok)
+	}
+	if abs := mod.AbsoluteFuncIndex(idx); abs != 1 {
+		t.Errorf("AbsoluteFuncIndex(%d) = %d, want 1", idx, abs)
+	}
+}
+
+func TestEnVarI64(t *testing.T) {
+	tests := []struct {
+		arg  varint64
+		want []byte
+	}{
+		{0, []byte{0}},
+		{-1, []byte{0x7f}},
+		{63, []byte{63}},
+		{64, []byte{0xc0, 0}},
+		{-64, []byte{0x40}},
+		{-65, []byte{0xbf, 0x7f}},
+		{1024, []byte{0x80, 0x08}},
+	}
+
+	for _, tt := range tests {
+		got := tt.arg.bytes()
+		if bytes.Compare(got, tt.want) != 0 {
+			t.Errorf("encode varint64(%d).bytes() = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestLimitsBytes(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := mod.section(MemoryID).(MemorySection)
+	if got := mem.memories[0].Limits.InitialBytes(); got != 2*WasmPageSize {
+		t.Errorf("InitialBytes() = %d, want %d", got, 2*WasmPageSize)
+	}
+	if _, ok := mem.memories[0].Limits.MaximumBytes(); ok {
+		t.Errorf("MaximumBytes() reports a maximum for an unbounded memory")
+	}
+
+	l := ResizableLimits{Flags: 1, Initial: 2, Maximum: 3}
+	if got := l.InitialBytes(); got != 131072 {
+		t.Errorf("InitialBytes() = %d, want 131072", got)
+	}
+	if got, ok := l.MaximumBytes(); !ok || got != 196608 {
+		t.Errorf("MaximumBytes() = %d, %v, want 196608, true", got, ok)
+	}
+
+	// the sizes of 64-bit memories saturate
+	l = ResizableLimits{Flags: 0x5, Initial: 1 << 47, Maximum: maxMemory64Pages}
+	if got := l.InitialBytes(); got != 1<<63 {
+		t.Errorf("InitialBytes() = %d, want %d", got, uint64(1<<63))
+	}
+	if got, ok := l.MaximumBytes(); !ok || got != math.MaxUint64 {
+		t.Errorf("MaximumBytes() = %d, %v, want %d, true", got, ok, uint64(math.MaxUint64))
+	}
+}
+
+func TestGlobalInitValue(t *testing.T) {
+	i32 := GlobalType{ContentType: ValueI32}
+	mod := Module{
+		Header: ModuleHeader{Magic: magicWASM, Version: 1},
+		Sections: []Section{
+			ImportSection{Imports: []ImportEntry{
+				{Module: "env", Field: "g", Kind: GlobalKind, Typ: i32},
+			}},
+			GlobalSection{globals: []GlobalVariable{
+				{Type: i32, Init: InitExpr{Op: Op_i32_const, Value: 7}},
+				{Type: i32, Init: InitExpr{Op: Op_get_global, Value: 0}},
+				{Type: i32, Init: InitExpr{Op: Op_get_global, Value: 1}},
+			}},
+		},
+	}
+	b, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		idx  uint32
+		want int64
+		ok   bool
+	}{
+		{0, 0, false}, // imported
+		{1, 7, true},
+		{2, 0, false}, // get_global of the imported global
+		{3, 0, false}, // get_global of a defined global is invalid
+		{4, 0, false}, // out of range
+	}
+	for _, tt := range tests {
+		got, ok := mod.GlobalInitValue(tt.idx)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("GlobalInitValue(%d) = %d, %v, want %d, %v", tt.idx, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestGlobalValues(t *testing.T) {
+	i32 := GlobalType{ContentType: ValueI32}
+	gs := GlobalSection{globals: []GlobalVariable{
+		{Type: i32, Init: InitExpr{Op: Op_i32_const, Value: 7}},
+		{Type: i32, Init: InitExpr{Op: Op_i32_const, Value: -1}},
+		{Type: i32, Init: InitExpr{Op: Op_get_global, Value: 0}},
+	}}
+	values, ok := gs.Values()
+	if len(values) != 3 || len(ok) != 3 {
+		t.Fatalf("got %d values, %d flags, want 3", len(values), len(ok))
+	}
+	if values[0] != 7 || !ok[0] || values[1] != -1 || !ok[1] {
+		t.Errorf("got %v %v, want 7 and -1", values[:2], ok[:2])
+	}
+	if ok[2] {
+		t.Errorf("get_global: got constant %d", values[2])
+	}
+}
+
+func TestDataStrings(t *testing.T) {
+	var b ModuleBuilder
+	b.AddMemory(ResizableLimits{Initial: 1})
+	b.AddData(0, []byte("\x00\x01hello\x00hi\x00"))
+	b.AddData(64, []byte("world!"))
+	mod, err := b.Module()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := mod.DataStrings(4)
+	want := []DataString{{0, 2, "hello"}, {1, 0, "world!"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("string %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := mod.DataStrings(2); len(got) != 3 || got[1].Value != "hi" {
+		t.Errorf("minLen 2: got %v, want hello, hi and world!", got)
+	}
+}
+
+func TestTypeString(t *testing.T) {
+	tests := []struct {
+		typ  fmt.Stringer
+		want string
+	}{
+		{MemoryType{Limits: ResizableLimits{Initial: 2}}, "{initial 2}"},
+		{MemoryType{Limits: ResizableLimits{Flags: 1, Initial: 1, Maximum: 10}}, "{initial 1 max 10}"},
+		{TableType{ElemType: ElemFuncRef, Limits: ResizableLimits{Initial: 1}}, "funcref {initial 1}"},
+		{GlobalType{ContentType: ValueI32}, "i32"},
+		{GlobalType{ContentType: ValueI64, Mutability: 1}, "(mut i64)"},
+	}
+	for _, tt := range tests {
+		if got := tt.typ.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestCustomSections(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mod.CustomSections("producers"); len(got) != 0 {
+		t.Errorf("got %d producers sections, want none", len(got))
+	}
+
+	p1 := []byte{0x01, 0x08, 'l', 'a', 'n', 'g', 'u', 'a', 'g', 'e', 0x00}
+	p2 := []byte{0x01, 0x0c, 'p', 'r', 'o', 'c', 'e', 's', 's', 'e', 'd', '-', 'b', 'y', 0x00}
+	mod.Sections = append(mod.Sections,
+		NameSection{Name: "producers", Payload: p1},
+		NameSection{Name: "other", Payload: []byte{1, 2, 3}},
+		NameSection{Name: "producers", Payload: p2})
+	b, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mod.CustomSections("producers")
+	if len(got) != 2 || !bytes.Equal(got[0], p1) || !bytes.Equal(got[1], p2) {
+		t.Errorf("CustomSections(producers) = %x, want [%x %x]", got, p1, p2)
+	}
+}
+
+func TestImportModules(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mod.ImportModules(); len(got) != 1 || got[0] != "ethereum" {
+		t.Errorf("ImportModules() = %q, want [ethereum]", got)
+	}
+
+	sig := NewFuncType([]ValueType{ValueI32}, nil)
+	for _, fn := range []string{"print32", "printMem"} {
+		if _, err := mod.AddImportFunc("debug", fn, sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := mod.ImportModules(); len(got) != 2 || got[0] != "debug" || got[1] != "ethereum" {
+		t.Errorf("ImportModules() = %q, want [debug ethereum]", got)
+	}
+
+	if got := codeModule(0x0b).ImportModules(); len(got) != 0 {
+		t.Errorf("ImportModules() = %q, want none", got)
+	}
+}
+
+func TestFunctionCount(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported, defined := mod.FunctionCount(); imported != 1 || defined != 1 {
+		t.Errorf("FunctionCount() = %d, %d, want 1, 1", imported, defined)
+	}
+	if err := mod.CheckFunctionCount(); err != nil {
+		t.Errorf("CheckFunctionCount: %v", err)
+	}
+
+	// two declared functions, a single body
+	bad := codeModule(0x0b)
+	bad.Sections[1] = FunctionSection{Types: []uint32{0, 0}}
+	if imported, defined := bad.FunctionCount(); imported != 0 || defined != 2 {
+		t.Errorf("FunctionCount() = %d, %d, want 0, 2", imported, defined)
+	}
+	checkValidation(t, bad.CheckFunctionCount(), CodeID, errFuncCount)
+}
+
+func TestModuleName(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := mod.ModuleName(); ok {
+		t.Errorf("ModuleName() = %q, true for a module without a name", name)
+	}
+
+	// name section holding the module name "hello"
+	b, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = append(b, 0x00, 0x0d, 0x04, 'n', 'a', 'm', 'e', 0x00, 0x06, 0x05, 'h', 'e', 'l', 'l', 'o')
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := mod.ModuleName(); !ok || name != "hello" {
+		t.Errorf("ModuleName() = %q, %v, want \"hello\", true", name, ok)
+	}
+}
+
+func TestHeaderBytes(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mod.HeaderBytes(); !bytes.Equal(got, b[:8]) {
+		t.Errorf("HeaderBytes() = % x, want % x", got, b[:8])
+	}
+}
+
+func TestFunctionName(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := mod.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// name section naming the imported function 0 and the defined function 1
+	b = append(b, 0x00, 0x16, 0x04, 'n', 'a', 'm', 'e', 0x01, 0x0f, 0x02,
+		0x00, 0x06, 'f', 'i', 'n', 'i', 's', 'h',
+		0x01, 0x04, 'M', 'a', 'i', 'n')
+	if mod, err = Parse(b); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		idx  uint32
+		name string
+		ok   bool
+	}{
+		{0, "finish", true},
+		{1, "Main", true},
+		{2, "", false},
+	}
+	for _, tt := range tests {
+		if name, ok := mod.FunctionName(tt.idx); name != tt.name || ok != tt.ok {
+			t.Errorf("FunctionName(%d) = %q, %v, want %q, %v", tt.idx, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestMaxMemoryPages(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max, ok := mod.MaxMemoryPages(); ok {
+		t.Errorf("unbounded memory: MaxMemoryPages() = %d, true", max)
+	}
+
+	bounded := Module{Sections: []Section{
+		ImportSection{Imports: []ImportEntry{{Module: "env", Field: "memory", Kind: MemoryKind,
+			Typ: MemoryType{Limits: ResizableLimits{Flags: 1, Initial: 1, Maximum: 16}}}}},
+	}}
+	if max, ok := bounded.MaxMemoryPages(); !ok || max != 16 {
+		t.Errorf("imported memory: MaxMemoryPages() = %d, %v, want 16, true", max, ok)
+	}
+	bounded.Sections[0] = MemorySection{memories: []MemoryType{
+		{Limits: ResizableLimits{Flags: 1, Initial: 2, Maximum: 8}}}}
+	if max, ok := bounded.MaxMemoryPages(); !ok || max != 8 {
+		t.Errorf("defined memory: MaxMemoryPages() = %d, %v, want 8, true", max, ok)
+	}
+	if max, ok := (Module{}).MaxMemoryPages(); ok {
+		t.Errorf("no memory: MaxMemoryPages() = %d, true", max)
+	}
+}
+
+func TestExportsOf(t *testing.T) {
+	buf, err := BuildEwasmModule([]ImportEntry{
+		{Module: "ethereum", Field: "finish", Kind: FunctionKind},
+	}, FunctionBody{Code: []byte{0x0b}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.AddExport("run", FunctionKind, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		kind  ExternalKind
+		index uint32
+		want  []string
+	}{
+		{FunctionKind, 1, []string{"main", "run"}},
+		{MemoryKind, 0, []string{"memory"}},
+		{FunctionKind, 0, nil},
+		{GlobalKind, 1, nil},
+	}
+	for _, tt := range tests {
+		got := mod.ExportsOf(tt.kind, tt.index)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("ExportsOf(%s, %d) = %q, want %q", tt.kind, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestExportedMemory(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, limits, ok := mod.ExportedMemory()
+	if !ok || idx != 0 || limits.Initial != 2 || limits.Flags&0x1 != 0 {
+		t.Errorf("ExportedMemory() = %d, %v, %v, want 0, 2 pages without maximum", idx, limits, ok)
+	}
+
+	mod.Sections = append(mod.Sections[:6:6], mod.Sections[7:]...)
+	if _, _, ok := mod.ExportedMemory(); ok {
+		t.Error("ExportedMemory() reports a memory for a module without exports")
+	}
+}
+
+func TestUpto(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(mod.Sections)
+	meta := mod.Upto(ExportID)
+	if last := meta.Sections[len(meta.Sections)-1].ID(); last != ExportID {
+		t.Errorf("last section: got %s, want %s", last, SectionID(ExportID))
+	}
+	for _, id := range []SectionID{CodeID, DataID} {
+		if meta.Has(id) {
+			t.Errorf("truncated module has a %s section", id)
+		}
+	}
+	if !meta.Has(ImportID) || len(mod.Sections) != n {
+		t.Errorf("got %d of %d sections", len(meta.Sections), len(mod.Sections))
+	}
+	if all := mod.Upto(StartID); len(all.Sections) != n {
+		t.Errorf("Upto a missing section: got %d sections, want %d", len(all.Sections), n)
+	}
+}
+
+func TestHas(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.SetModuleName("hello")
+	mod = reparse(t, mod)
+	if !mod.Has(TypeID) || mod.Has(StartID) {
+		t.Errorf("Has(type), Has(start) = %v, %v, want true, false", mod.Has(TypeID), mod.Has(StartID))
+	}
+	if !mod.HasCustom("name") || mod.HasCustom("producers") {
+		t.Errorf("HasCustom(name), HasCustom(producers) = %v, %v, want true, false",
+			mod.HasCustom("name"), mod.HasCustom("producers"))
+	}
+}
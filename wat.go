@@ -0,0 +1,404 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WAT returns the text format of the module.
+func (m Module) WAT() (string, error) {
+	var sb strings.Builder
+	err := m.WriteWAT(&sb)
+	return sb.String(), err
+}
+
+// WriteWAT writes the text format of the module to w. The output is flushed
+// after every function, the memory used does not grow with the module.
+func (m Module) WriteWAT(w io.Writer) error {
+	return m.writeWAT(w, false)
+}
+
+// WriteWATHeader writes the text format of the module without its defined
+// functions, a quick view of its types, imports and exports.
+func (m Module) WriteWATHeader(w io.Writer) error {
+	return m.writeWAT(w, true)
+}
+
+type watWriter struct {
+	*bufio.Writer
+	mc moduleContext
+}
+
+func (m Module) writeWAT(w io.Writer, header bool) error {
+	ww := watWriter{Writer: bufio.NewWriter(w), mc: m.context()}
+	ww.WriteString("(module\n")
+	for i := range ww.mc.types {
+		fmt.Fprintf(ww, "  (type (;%d;) %s)\n", i, ww.mc.types[i].String())
+	}
+
+	var nFuncs, nTables, nMems, nGlobals int
+	if s, ok := m.section(ImportID).(ImportSection); ok {
+		for _, imp := range s.Imports {
+			fmt.Fprintf(ww, "  (import %s %s ", watString(imp.Module), watString(imp.Field))
+			switch typ := imp.Typ.(type) {
+			case uint32:
+				fmt.Fprintf(ww, "(func (;%d;) (type %d))", nFuncs, typ)
+				nFuncs++
+			case TableType:
+				fmt.Fprintf(ww, "(table (;%d;) %s %s)", nTables, watLimits(typ.Limits), typ.ElemType)
+				nTables++
+			case MemoryType:
+				fmt.Fprintf(ww, "(memory (;%d;) %s)", nMems, watLimits(typ.Limits))
+				nMems++
+			case GlobalType:
+				fmt.Fprintf(ww, "(global (;%d;) %s)", nGlobals, typ)
+				nGlobals++
+			}
+			ww.WriteString(")\n")
+		}
+	}
+
+	if fs, ok := m.section(FunctionID).(FunctionSection); ok && !header {
+		code, _ := m.section(CodeID).(CodeSection)
+		if code.Skipped != 0 {
+			return errSkippedCode
+		}
+		for i, typ := range fs.Types {
+			if i >= len(code.Bodies) {
+				return errFuncCount
+			}
+			if err := ww.writeFunc(nFuncs+i, typ, &code.Bodies[i]); err != nil {
+				return err
+			}
+			if err := ww.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s, ok := m.section(TableID).(TableSection); ok {
+		for i, tt := range s.tables {
+			fmt.Fprintf(ww, "  (table (;%d;) %s %s)\n", nTables+i, watLimits(tt.Limits), tt.ElemType)
+		}
+	}
+	if s, ok := m.section(MemoryID).(MemorySection); ok {
+		for i, mt := range s.memories {
+			fmt.Fprintf(ww, "  (memory (;%d;) %s)\n", nMems+i, watLimits(mt.Limits))
+		}
+	}
+	if s, ok := m.section(TagID).(TagSection); ok {
+		for i, tag := range s.Tags {
+			fmt.Fprintf(ww, "  (tag (;%d;) (type %d))\n", i, tag.Type)
+		}
+	}
+	if s, ok := m.section(GlobalID).(GlobalSection); ok {
+		for i, gv := range s.globals {
+			fmt.Fprintf(ww, "  (global (;%d;) %s %s)\n", nGlobals+i, gv.Type, watInitExpr(gv.Init))
+		}
+	}
+	if s, ok := m.section(ExportID).(ExportSection); ok {
+		for _, ee := range s.Exports {
+			fmt.Fprintf(ww, "  (export %s (%s %d))\n", watString(ee.Field), ee.Kind, ee.Index)
+		}
+	}
+	if s, ok := m.section(StartID).(StartSection); ok {
+		fmt.Fprintf(ww, "  (start %d)\n", s.Index)
+	}
+	if s, ok := m.section(ElementID).(ElementSection); ok {
+		for i, es := range s.elements {
+			fmt.Fprintf(ww, "  (elem (;%d;) ", i)
+			switch {
+			case es.Flags&0x3 == 0x3:
+				ww.WriteString("declare ")
+			case es.Flags&0x1 == 0:
+				if es.Index != 0 {
+					fmt.Fprintf(ww, "(table %d) ", es.Index)
+				}
+				ww.WriteString(watInitExpr(es.Offset) + " ")
+			}
+			if es.Flags&0x4 != 0 {
+				et := es.Type
+				if et == 0 {
+					et = ElemFuncRef
+				}
+				ww.WriteString(et.String())
+				for _, ie := range es.Exprs {
+					ww.WriteString(" " + watInitExpr(ie))
+				}
+			} else {
+				ww.WriteString("func")
+				for _, idx := range es.Elems {
+					fmt.Fprintf(ww, " %d", idx)
+				}
+			}
+			ww.WriteString(")\n")
+		}
+	}
+	if s, ok := m.section(DataID).(DataSection); ok {
+		for i, ds := range s.segments {
+			fmt.Fprintf(ww, "  (data (;%d;) ", i)
+			if ds.Index != 0 {
+				fmt.Fprintf(ww, "(memory %d) ", ds.Index)
+			}
+			fmt.Fprintf(ww, "%s %s)\n", watInitExpr(ds.Offset), watString(string(ds.Data)))
+		}
+	}
+	ww.WriteString(")\n")
+	return ww.Flush()
+}
+
+// watMaxLocals is the largest count of a local entry written in full.
+const watMaxLocals = 16
+
+// writeFunc writes the function idx of type typ with its body.
+func (ww *watWriter) writeFunc(idx int, typ uint32, fb *FunctionBody) error {
+	insns, err := fb.decode(uint32(idx))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ww, "  (func (;%d;) (type %d)", idx, typ)
+	if ft := ww.mc.typeAt(typ); ft != nil {
+		ww.writeValueTypes(" (param", ft.params)
+		ww.writeValueTypes(" (result", ft.results)
+	}
+	ww.WriteString("\n")
+	for _, le := range fb.Locals {
+		// a long run of locals is written once with its count in a comment
+		if le.Count > watMaxLocals {
+			fmt.Fprintf(ww, "    (local %s) (;x%d;)\n", le.Type, le.Count)
+			continue
+		}
+		ww.WriteString("    (local")
+		for i := uint32(0); i < le.Count; i++ {
+			ww.WriteString(" " + le.Type.String())
+		}
+		ww.WriteString(")\n")
+	}
+
+	depth := 2
+	for i, ins := range insns {
+		if ins.Op == Op_end && i == len(insns)-1 {
+			break
+		}
+		if closesBlock(ins.Op) && depth > 2 {
+			depth--
+		}
+		ww.WriteString(strings.Repeat("  ", depth) + ins.String() + "\n")
+		if opensBlock(ins.Op) {
+			depth++
+		}
+	}
+	ww.WriteString("  )\n")
+	return nil
+}
+
+// DisassembleOptions controls the output of DisassembleFunc.
+type DisassembleOptions struct {
+	// Numbered prefixes each line with the index of the instruction and its
+	// hex offset within the code, so that disassemblies diff line by line.
+	Numbered bool
+}
+
+// DisassembleFunc returns the instructions of the function of absolute
+// index idx in text format, one per line.
+func (m Module) DisassembleFunc(idx uint32, opts DisassembleOptions) (string, error) {
+	fb, err := m.funcBody(idx)
+	if err != nil {
+		return "", err
+	}
+	insns, err := fb.decode(idx)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	depth := 0
+	for i, ins := range insns {
+		if closesBlock(ins.Op) && depth > 0 {
+			depth--
+		}
+		if opts.Numbered {
+			fmt.Fprintf(&sb, "%4d %06x  ", i, ins.Offset)
+		}
+		sb.WriteString(strings.Repeat("  ", depth) + ins.String() + "\n")
+		if opensBlock(ins.Op) {
+			depth++
+		}
+	}
+	return sb.String(), nil
+}
+
+// opensBlock reports whether the instructions following op are nested one
+// level deeper: those of a block or of the arm of an if or a try.
+func opensBlock(op Opcode) bool {
+	switch op {
+	case Op_block, Op_loop, Op_if, Op_else, Op_try, Op_catch, Op_catch_all:
+		return true
+	}
+	return false
+}
+
+// closesBlock reports whether op ends a block or the arm of an if or a try.
+func closesBlock(op Opcode) bool {
+	switch op {
+	case Op_end, Op_else, Op_catch, Op_catch_all, Op_delegate:
+		return true
+	}
+	return false
+}
+
+func (ww *watWriter) writeValueTypes(prefix string, vts []ValueType) {
+	if len(vts) == 0 {
+		return
+	}
+	ww.WriteString(prefix)
+	for _, vt := range vts {
+		ww.WriteString(" " + vt.String())
+	}
+	ww.WriteString(")")
+}
+
+// String returns the text format of the instruction.
+func (ins Instruction) String() string {
+	s := ins.Op.String()
+	switch opImmediate(ins.Op) {
+	case immBlock:
+		if ins.Block >= 0 {
+			s += fmt.Sprintf(" (type %d)", ins.Block)
+		} else if ins.Block != ValueBlock {
+			s += " (result " + ValueType(ins.Block).String() + ")"
+		}
+	case immIndex:
+		s += " " + strconv.FormatUint(uint64(ins.Index), 10)
+	case immBrTable:
+		for _, l := range ins.Targets {
+			s += " " + strconv.FormatUint(uint64(l), 10)
+		}
+		s += " " + strconv.FormatUint(uint64(ins.Index), 10)
+	case immCallIndirect:
+		s += fmt.Sprintf(" (type %d)", ins.Index)
+	case immMemArg:
+		if ins.Mem.Offset != 0 {
+			s += fmt.Sprintf(" offset=%d", ins.Mem.Offset)
+		}
+		if ins.Mem.Align != memOps[ins.Op].align {
+			s += fmt.Sprintf(" align=%d", uint64(1)<<ins.Mem.Align)
+		}
+	case immI32:
+		s += " " + strconv.FormatInt(int64(int32(ins.Value)), 10)
+	case immI64:
+		s += " " + strconv.FormatInt(ins.Value, 10)
+	case immF32:
+		s += " " + watF32(uint32(ins.Value))
+	case immF64:
+		s += " " + watF64(uint64(ins.Value))
+	case immSimd:
+		s = "v128.const " + watV128(ins.V128)
+	}
+	return s
+}
+
+func watInitExpr(ie InitExpr) string {
+	switch ie.Op {
+	case Op_i32_const, Op_unreachable:
+		return fmt.Sprintf("(i32.const %d)", int32(ie.Value))
+	case Op_i64_const:
+		return fmt.Sprintf("(i64.const %d)", ie.Value)
+	case Op_f32_const:
+		return "(f32.const " + watF32(uint32(ie.Value)) + ")"
+	case Op_f64_const:
+		return "(f64.const " + watF64(uint64(ie.Value)) + ")"
+	case Op_get_global:
+		return fmt.Sprintf("(global.get %d)", ie.Value)
+	case Op_simd_prefix:
+		return "(v128.const " + watV128(ie.V128) + ")"
+	case Op_ref_null:
+		if ElemType(ie.Value) == ElemExternRef {
+			return "(ref.null extern)"
+		}
+		return "(ref.null func)"
+	case Op_ref_func:
+		return fmt.Sprintf("(ref.func %d)", ie.Value)
+	}
+	return fmt.Sprintf("(;invalid %s;)", ie.Op)
+}
+
+func watLimits(l ResizableLimits) string {
+	s := strconv.FormatUint(l.Initial, 10)
+	if l.Memory64() {
+		s = "i64 " + s
+	}
+	if (l.Flags & 0x1) != 0 {
+		s += " " + strconv.FormatUint(l.Maximum, 10)
+	}
+	if l.Shared() {
+		s += " shared"
+	}
+	return s
+}
+
+func watV128(v [16]byte) string {
+	return fmt.Sprintf("i32x4 0x%08x 0x%08x 0x%08x 0x%08x",
+		order.Uint32(v[0:]), order.Uint32(v[4:]), order.Uint32(v[8:]), order.Uint32(v[12:]))
+}
+
+func watF32(bits uint32) string {
+	f := math.Float32frombits(bits)
+	if f != f {
+		return watNaN(bits>>31 != 0, uint64(bits&0x7fffff), 0x400000)
+	}
+	return watFloat(float64(f), 32)
+}
+
+func watF64(bits uint64) string {
+	f := math.Float64frombits(bits)
+	if f != f {
+		return watNaN(bits>>63 != 0, bits&0xfffffffffffff, 0x8000000000000)
+	}
+	return watFloat(f, 64)
+}
+
+func watFloat(f float64, size int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, size)
+}
+
+// watNaN formats a NaN, with its payload unless it is the canonical one.
+func watNaN(neg bool, payload, canonical uint64) string {
+	s := "nan"
+	if payload != canonical {
+		s += fmt.Sprintf(":0x%x", payload)
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// watString quotes s, escaping the bytes which are not printable ASCII.
+func watString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%02x", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
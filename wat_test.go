@@ -0,0 +1,124 @@
+// Copyright 2016 The wasm Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWAT(t *testing.T) {
+	mod, err := Open("testdata/hello.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wat, err := mod.WAT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"(type (;0;) (func (param i32 i32)))",
+		`(import "ethereum" "finish" (func (;0;) (type 0)))`,
+		"  (func (;1;) (type 1)\n    i32.const 1024\n    i32.const 8\n    call 0\n  )\n",
+		"(table (;0;) 1 1 funcref)",
+		"(memory (;0;) 2)",
+		"(global (;0;) (mut i32) (i32.const 66576))",
+		`(export "Main" (func 1))`,
+		`(data (;0;) (i32.const 1024) "\00\00\00\00\00\00\00\0a")`,
+	} {
+		if !strings.Contains(wat, want) {
+			t.Errorf("WAT missing %q:\n%s", want, wat)
+		}
+	}
+
+	var sb strings.Builder
+	if err := mod.WriteWATHeader(&sb); err != nil {
+		t.Fatal(err)
+	}
+	header := sb.String()
+	for _, want := range []string{"(type (;1;)", "(import ", `(export "memory" (memory 0))`} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header missing %q:\n%s", want, header)
+		}
+	}
+	if strings.Contains(header, "\n  (func") || strings.Contains(header, "call 0") {
+		t.Errorf("header holds function bodies:\n%s", header)
+	}
+}
+
+func TestInstructionString(t *testing.T) {
+	tests := []struct {
+		ins  Instruction
+		want string
+	}{
+		{Instruction{Op: Op_block, Block: ValueBlock}, "block"},
+		{Instruction{Op: Op_loop, Block: BlockType(ValueI64)}, "loop (result i64)"},
+		{Instruction{Op: Op_br_table, Targets: []uint32{0, 2}, Index: 1}, "br_table 0 2 1"},
+		{Instruction{Op: Op_call_indirect, Index: 3}, "call_indirect (type 3)"},
+		{Instruction{Op: Op_get_local, Index: 2}, "local.get 2"},
+		{Instruction{Op: Op_i32_load, Mem: MemArg{Align: 2, Offset: 8}}, "i32.load offset=8"},
+		{Instruction{Op: Op_i64_store8, Mem: MemArg{Align: 1}}, "i64.store8 align=2"},
+		{Instruction{Op: Op_i32_const, Value: -1}, "i32.const -1"},
+		{Instruction{Op: Op_f32_const, Value: 0x3fc00000}, "f32.const 1.5"},
+		{Instruction{Op: Op_f64_const, Value: 0x7ff8000000000000}, "f64.const nan"},
+		{Instruction{Op: Op_f64_const, Value: -0x10000000000000}, "f64.const -inf"},
+		{Instruction{Op: Op_i64_trunc_u_f64}, "i64.trunc_f64_u"},
+		{Instruction{Op: Op_current_memory}, "memory.size"},
+	}
+	for _, tt := range tests {
+		if got := tt.ins.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.ins, got, tt.want)
+		}
+	}
+}
+
+func TestDisassembleFunc(t *testing.T) {
+	// block (i32.const 1, br_if 0), nop, end
+	mod := codeModule(0x02, 0x40, 0x41, 0x01, 0x0d, 0x00, 0x0b, 0x01, 0x0b)
+	plain, err := mod.DisassembleFunc(0, DisassembleOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "block\n  i32.const 1\n  br_if 0\nend\nnop\nend\n"
+	if plain != want {
+		t.Errorf("got\n%s\nwant\n%s", plain, want)
+	}
+
+	numbered, err := mod.DisassembleFunc(0, DisassembleOptions{Numbered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(numbered, "\n"), "\n")
+	plainLines := strings.Split(strings.TrimSuffix(plain, "\n"), "\n")
+	if len(lines) != len(plainLines) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(plainLines))
+	}
+	last := int64(-1)
+	for i, line := range lines {
+		var n int
+		var off int64
+		if _, err := fmt.Sscanf(line, "%d %x", &n, &off); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if n != i {
+			t.Errorf("line %q: got index %d, want %d", line, n, i)
+		}
+		if off <= last {
+			t.Errorf("line %q: offset %#x not after %#x", line, off, last)
+		}
+		last = off
+		if !strings.HasSuffix(line, "  "+plainLines[i]) {
+			t.Errorf("line %q does not end with %q", line, plainLines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[1], "   1 000002  ") {
+		t.Errorf("got %q, want index 1 at offset 2", lines[1])
+	}
+
+	if _, err := mod.DisassembleFunc(1, DisassembleOptions{}); err != errBadIndex {
+		t.Errorf("out of range: got %v, want %v", err, errBadIndex)
+	}
+}